@@ -2,24 +2,66 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Supported fortune backends.
+const (
+	// BackendExec runs the fortune binary for every request.
+	BackendExec = "exec"
+	// BackendNative reads the fortune databases in process.
+	BackendNative = "native"
+)
+
+// DefaultOffensiveDir is the offensive sub-directory assumed for a fortune
+// directory that doesn't name one, as laid out by fortune-mod.
+const DefaultOffensiveDir = "off"
+
+// FortuneDir is a directory of fortune databases. OffensiveDir holds its
+// offensive databases and is relative to Path unless absolute; it is empty
+// when the directory has none.
+type FortuneDir struct {
+	Path         string
+	OffensiveDir string
+}
+
 type Config struct {
-	ServerAddress string
-	FortunePath   string
-	ReadTimeout   time.Duration
-	WriteTimeout  time.Duration
-	IdleTimeout   time.Duration
+	ServerAddress  string
+	Backend        string
+	FortunePath    string
+	FortuneDirs    []FortuneDir
+	SafeMode       bool
+	MaxCount       int
+	MaxSearchLimit int
+	// DenyIDs and DenyPatterns retire fortunes by ID or by a regular
+	// expression over their text.
+	DenyIDs        []string
+	DenyPatterns   []string
+	IndexRefresh   time.Duration
+	RequestTimeout time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	IdleTimeout    time.Duration
 }
 
 func Load() *Config {
 	return &Config{
-		ServerAddress: getEnv("SERVER_ADDRESS", ":8080"),
-		FortunePath:   getEnv("FORTUNE_PATH", "/usr/games/fortune"),
-		ReadTimeout:   getDurationEnv("READ_TIMEOUT", 15*time.Second),
-		WriteTimeout:  getDurationEnv("WRITE_TIMEOUT", 15*time.Second),
-		IdleTimeout:   getDurationEnv("IDLE_TIMEOUT", 60*time.Second),
+		ServerAddress:  getEnv("SERVER_ADDRESS", ":8080"),
+		Backend:        getEnv("FORTUNE_BACKEND", BackendExec),
+		FortunePath:    getEnv("FORTUNE_PATH", "fortune"),
+		FortuneDirs:    getFortuneDirsEnv("FORTUNE_DIRS", getEnv("FORTUNE_DIR", "/usr/share/games/fortunes")),
+		SafeMode:       getBoolEnv("SAFE_MODE", false),
+		MaxCount:       getIntEnv("MAX_FORTUNE_COUNT", 10),
+		MaxSearchLimit: getIntEnv("MAX_SEARCH_LIMIT", 100),
+		DenyIDs:        getListEnv("DENY_IDS", ","),
+		DenyPatterns:   getListEnv("DENY_PATTERNS", "\n"),
+		IndexRefresh:   getDurationEnv("INDEX_REFRESH", 30*time.Second),
+		RequestTimeout: getDurationEnv("REQUEST_TIMEOUT", 10*time.Second),
+		ReadTimeout:    getDurationEnv("READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:   getDurationEnv("WRITE_TIMEOUT", 15*time.Second),
+		IdleTimeout:    getDurationEnv("IDLE_TIMEOUT", 60*time.Second),
 	}
 }
 
@@ -38,3 +80,56 @@ func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	}
 	return defaultValue
 }
+
+func getIntEnv(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+func getBoolEnv(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+// getListEnv splits a variable into its non-blank entries. Regular
+// expressions can hold commas, so lists of them are split on newlines.
+func getListEnv(key, sep string) []string {
+	var list []string
+	for _, entry := range strings.Split(os.Getenv(key), sep) {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// getFortuneDirsEnv reads an ordered, comma-separated list of fortune
+// directories. Each entry may name its offensive sub-directory after an "=",
+// as in "/opt/fortunes=nsfw"; "/opt/fortunes=" declares that it has none,
+// and a bare path uses DefaultOffensiveDir.
+func getFortuneDirsEnv(key, defaultValue string) []FortuneDir {
+	value := getEnv(key, defaultValue)
+
+	var dirs []FortuneDir
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		path, off, found := strings.Cut(entry, "=")
+		if !found {
+			off = DefaultOffensiveDir
+		}
+		dirs = append(dirs, FortuneDir{Path: path, OffensiveDir: off})
+	}
+	return dirs
+}
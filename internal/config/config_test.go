@@ -13,7 +13,17 @@ func TestLoadConfig(t *testing.T) {
 	t.Run("Defaults", func(t *testing.T) {
 		// Ensure environment variables are unset for this test.
 		os.Unsetenv("SERVER_ADDRESS")
+		os.Unsetenv("FORTUNE_BACKEND")
 		os.Unsetenv("FORTUNE_PATH")
+		os.Unsetenv("FORTUNE_DIR")
+		os.Unsetenv("FORTUNE_DIRS")
+		os.Unsetenv("SAFE_MODE")
+		os.Unsetenv("MAX_FORTUNE_COUNT")
+		os.Unsetenv("MAX_SEARCH_LIMIT")
+		os.Unsetenv("DENY_IDS")
+		os.Unsetenv("DENY_PATTERNS")
+		os.Unsetenv("INDEX_REFRESH")
+		os.Unsetenv("REQUEST_TIMEOUT")
 		os.Unsetenv("READ_TIMEOUT")
 		os.Unsetenv("WRITE_TIMEOUT")
 		os.Unsetenv("IDLE_TIMEOUT")
@@ -21,7 +31,16 @@ func TestLoadConfig(t *testing.T) {
 		cfg := Load()
 
 		assert.Equal(t, ":8080", cfg.ServerAddress)
+		assert.Equal(t, BackendExec, cfg.Backend)
 		assert.Equal(t, "fortune", cfg.FortunePath)
+		assert.Equal(t, []FortuneDir{{Path: "/usr/share/games/fortunes", OffensiveDir: "off"}}, cfg.FortuneDirs)
+		assert.False(t, cfg.SafeMode)
+		assert.Equal(t, 10, cfg.MaxCount)
+		assert.Equal(t, 100, cfg.MaxSearchLimit)
+		assert.Empty(t, cfg.DenyIDs)
+		assert.Empty(t, cfg.DenyPatterns)
+		assert.Equal(t, 30*time.Second, cfg.IndexRefresh)
+		assert.Equal(t, 10*time.Second, cfg.RequestTimeout)
 		assert.Equal(t, 15*time.Second, cfg.ReadTimeout)
 		assert.Equal(t, 15*time.Second, cfg.WriteTimeout)
 		assert.Equal(t, 60*time.Second, cfg.IdleTimeout)
@@ -30,14 +49,28 @@ func TestLoadConfig(t *testing.T) {
 	// Test case 2: All environment variables are set.
 	t.Run("From Environment", func(t *testing.T) {
 		os.Setenv("SERVER_ADDRESS", ":9090")
+		os.Setenv("FORTUNE_BACKEND", "native")
 		os.Setenv("FORTUNE_PATH", "/usr/local/bin/fortune")
+		os.Setenv("FORTUNE_DIR", "/opt/fortunes")
+		os.Setenv("SAFE_MODE", "true")
+		os.Setenv("MAX_FORTUNE_COUNT", "25")
+		os.Setenv("MAX_SEARCH_LIMIT", "500")
+		os.Setenv("INDEX_REFRESH", "0s")
+		os.Setenv("REQUEST_TIMEOUT", "3s")
 		os.Setenv("READ_TIMEOUT", "5s")
 		os.Setenv("WRITE_TIMEOUT", "10s")
 		os.Setenv("IDLE_TIMEOUT", "120s")
 
 		// Defer unsetting to clean up after the test.
 		defer os.Unsetenv("SERVER_ADDRESS")
+		defer os.Unsetenv("FORTUNE_BACKEND")
 		defer os.Unsetenv("FORTUNE_PATH")
+		defer os.Unsetenv("FORTUNE_DIR")
+		defer os.Unsetenv("SAFE_MODE")
+		defer os.Unsetenv("MAX_FORTUNE_COUNT")
+		defer os.Unsetenv("MAX_SEARCH_LIMIT")
+		defer os.Unsetenv("INDEX_REFRESH")
+		defer os.Unsetenv("REQUEST_TIMEOUT")
 		defer os.Unsetenv("READ_TIMEOUT")
 		defer os.Unsetenv("WRITE_TIMEOUT")
 		defer os.Unsetenv("IDLE_TIMEOUT")
@@ -45,13 +78,49 @@ func TestLoadConfig(t *testing.T) {
 		cfg := Load()
 
 		assert.Equal(t, ":9090", cfg.ServerAddress)
+		assert.Equal(t, BackendNative, cfg.Backend)
 		assert.Equal(t, "/usr/local/bin/fortune", cfg.FortunePath)
+		assert.Equal(t, []FortuneDir{{Path: "/opt/fortunes", OffensiveDir: "off"}}, cfg.FortuneDirs)
+		assert.True(t, cfg.SafeMode)
+		assert.Equal(t, 25, cfg.MaxCount)
+		assert.Equal(t, 500, cfg.MaxSearchLimit)
+		assert.Equal(t, time.Duration(0), cfg.IndexRefresh)
+		assert.Equal(t, 3*time.Second, cfg.RequestTimeout)
 		assert.Equal(t, 5*time.Second, cfg.ReadTimeout)
 		assert.Equal(t, 10*time.Second, cfg.WriteTimeout)
 		assert.Equal(t, 120*time.Second, cfg.IdleTimeout)
 	})
 
-	// Test case 3: Invalid duration format, should fall back to default.
+	// Test case 3: An ordered list of fortune directories.
+	t.Run("Fortune Directories", func(t *testing.T) {
+		os.Setenv("FORTUNE_DIR", "/ignored")
+		os.Setenv("FORTUNE_DIRS", "/home/me/fortunes=nsfw, /usr/share/fortune,/srv/quotes=,")
+		defer os.Unsetenv("FORTUNE_DIR")
+		defer os.Unsetenv("FORTUNE_DIRS")
+
+		cfg := Load()
+
+		assert.Equal(t, []FortuneDir{
+			{Path: "/home/me/fortunes", OffensiveDir: "nsfw"},
+			{Path: "/usr/share/fortune", OffensiveDir: "off"},
+			{Path: "/srv/quotes", OffensiveDir: ""},
+		}, cfg.FortuneDirs)
+	})
+
+	// Test case 4: A denylist of IDs and patterns.
+	t.Run("Denylist", func(t *testing.T) {
+		os.Setenv("DENY_IDS", "wisdom-3f2a9c0d1b7e4a65, zippy-5b0e17a2c4d9f836,")
+		os.Setenv("DENY_PATTERNS", "(?i)\\bpolitics\\b\n\n^.{0,3}$\n")
+		defer os.Unsetenv("DENY_IDS")
+		defer os.Unsetenv("DENY_PATTERNS")
+
+		cfg := Load()
+
+		assert.Equal(t, []string{"wisdom-3f2a9c0d1b7e4a65", "zippy-5b0e17a2c4d9f836"}, cfg.DenyIDs)
+		assert.Equal(t, []string{`(?i)\bpolitics\b`, "^.{0,3}$"}, cfg.DenyPatterns, "patterns are split on newlines, as they may hold commas")
+	})
+
+	// Test case 5: Invalid duration format, should fall back to default.
 	t.Run("Invalid Duration", func(t *testing.T) {
 		os.Setenv("READ_TIMEOUT", "not-a-duration")
 		defer os.Unsetenv("READ_TIMEOUT")
@@ -0,0 +1,171 @@
+package fortunefile
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// IndexSuffix is appended to a database path to find its strfile index.
+const IndexSuffix = ".dat"
+
+// File is a fortune database: a text file and its strfile index.
+type File struct {
+	// Path is the location of the text file.
+	Path  string
+	Index *Index
+}
+
+// Open loads the index for the fortune text file at path. The index is
+// expected at path+".dat".
+func Open(path string) (*File, error) {
+	idx, err := LoadIndex(path + IndexSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &File{Path: path, Index: idx}, nil
+}
+
+// Len returns the number of fortunes in the database.
+func (f *File) Len() int {
+	return int(f.Index.NumStr)
+}
+
+// Fortune returns the i-th fortune in index order, decoded if the database
+// is rot13 encoded. The trailing newline is removed.
+func (f *File) Fortune(i int) (string, error) {
+	if i < 0 || i >= f.Len() {
+		return "", ErrOutOfRange
+	}
+
+	fh, err := os.Open(f.Path)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	if _, err := fh.Seek(int64(f.Index.Offsets[i]), io.SeekStart); err != nil {
+		return "", err
+	}
+
+	text, err := f.readOne(bufio.NewReader(fh))
+	if err != nil {
+		return "", fmt.Errorf("%s: fortune %d: %w", f.Path, i, err)
+	}
+	return text, nil
+}
+
+// All returns every fortune in index order. It reads the text file once, so
+// it is cheaper than calling Fortune in a loop.
+func (f *File) All() ([]string, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	fortunes := make([]string, f.Len())
+	for i := range fortunes {
+		off := f.Index.Offsets[i]
+		if int64(off) > int64(len(data)) {
+			return nil, fmt.Errorf("%s: fortune %d: offset %d past end of file", f.Path, i, off)
+		}
+		text, err := f.readOne(bufio.NewReader(bytes.NewReader(data[off:])))
+		if err != nil {
+			return nil, fmt.Errorf("%s: fortune %d: %w", f.Path, i, err)
+		}
+		fortunes[i] = text
+	}
+	return fortunes, nil
+}
+
+// readOne reads lines from r up to the next delimiter line or EOF.
+func (f *File) readOne(r *bufio.Reader) (string, error) {
+	delim := f.Index.Delimiter
+	var sb strings.Builder
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			if isDelimiterLine(line, delim) {
+				break
+			}
+			if !f.isComment(line) {
+				sb.Write(line)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	text := strings.TrimSuffix(sb.String(), "\n")
+	if f.Index.Rotated() {
+		text = Rot13(text)
+	}
+	return text, nil
+}
+
+func (f *File) isComment(line []byte) bool {
+	if f.Index.Flags&FlagComments == 0 {
+		return false
+	}
+	d := f.Index.Delimiter
+	return len(line) >= 2 && line[0] == d && line[1] == d
+}
+
+// Rot13 applies the rot13 substitution used by offensive databases.
+// Applying it twice returns the original text.
+func Rot13(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return 'a' + (r-'a'+13)%26
+		case r >= 'A' && r <= 'Z':
+			return 'A' + (r-'A'+13)%26
+		}
+		return r
+	}, s)
+}
+
+// Create writes fortunes to a new database at path, followed by its index at
+// path+".dat". With FlagRotated the text is rot13 encoded on disk.
+func Create(path string, fortunes []string, flags uint32) (*File, error) {
+	var buf bytes.Buffer
+	for _, text := range fortunes {
+		if flags&FlagRotated != 0 {
+			text = Rot13(text)
+		}
+		buf.WriteString(text)
+		buf.WriteString("\n%\n")
+	}
+
+	idx, err := BuildIndex(bytes.NewReader(buf.Bytes()), DefaultDelimiter, flags, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return nil, err
+	}
+
+	dat, err := os.Create(path + IndexSuffix)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := idx.WriteTo(dat); err != nil {
+		dat.Close()
+		return nil, err
+	}
+	if err := dat.Close(); err != nil {
+		return nil, err
+	}
+
+	return &File{Path: path, Index: idx}, nil
+}
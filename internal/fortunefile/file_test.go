@@ -0,0 +1,106 @@
+package fortunefile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var sampleFortunes = []string{
+	"Zebras are striped.",
+	"Apples fall.\nNewton noticed.",
+	"\"Quoted\" maxim.",
+}
+
+func TestCreateAndOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample")
+
+	_, err := Create(path, sampleFortunes, 0)
+	require.NoError(t, err)
+
+	f, err := Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, f.Len())
+
+	for i, want := range sampleFortunes {
+		got, err := f.Fortune(i)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	all, err := f.All()
+	require.NoError(t, err)
+	assert.Equal(t, sampleFortunes, all)
+
+	_, err = f.Fortune(3)
+	assert.ErrorIs(t, err, ErrOutOfRange)
+}
+
+func TestOpen_MissingIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample")
+	require.NoError(t, os.WriteFile(path, []byte("text\n%\n"), 0o644))
+
+	_, err := Open(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestFile_Rotated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "off")
+
+	_, err := Create(path, sampleFortunes, FlagRotated)
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "Zebras", "text should be rot13 encoded on disk")
+
+	f, err := Open(path)
+	require.NoError(t, err)
+
+	all, err := f.All()
+	require.NoError(t, err)
+	assert.Equal(t, sampleFortunes, all)
+}
+
+func TestFile_OrderedAndRandom(t *testing.T) {
+	for _, flags := range []uint32{FlagOrdered, FlagRandom} {
+		path := filepath.Join(t.TempDir(), "sample")
+
+		_, err := Create(path, sampleFortunes, flags)
+		require.NoError(t, err)
+
+		f, err := Open(path)
+		require.NoError(t, err)
+
+		all, err := f.All()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, sampleFortunes, all)
+
+		first, err := f.Fortune(0)
+		require.NoError(t, err)
+		assert.Equal(t, all[0], first)
+	}
+}
+
+func TestFile_Comments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample")
+	text := "%% a comment\nFirst.\n%\nSecond.\n%% trailing comment\n%\n"
+	require.NoError(t, os.WriteFile(path, []byte(text), 0o644))
+
+	f := &File{Path: path, Index: &Index{
+		Header:  Header{Version: Version, NumStr: 2, Flags: FlagComments, Delimiter: '%'},
+		Offsets: []uint32{0, 22, uint32(len(text))},
+	}}
+
+	all, err := f.All()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"First.", "Second."}, all)
+}
+
+func TestRot13(t *testing.T) {
+	assert.Equal(t, "Uryyb, Jbeyq!", Rot13("Hello, World!"))
+	assert.Equal(t, "Hello, World!", Rot13(Rot13("Hello, World!")))
+}
@@ -0,0 +1,262 @@
+// Package fortunefile reads fortune databases without the fortune binary.
+//
+// A fortune database is a plain text file of fortunes separated by lines
+// holding a single delimiter character (usually '%'), together with a
+// strfile(1) index stored next to it with a ".dat" suffix. The index starts
+// with a fixed-size header followed by a table of big-endian byte offsets,
+// one per fortune plus a trailing end-of-file offset.
+package fortunefile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Header flags as defined by strfile(1).
+const (
+	FlagRandom   uint32 = 0x1 // offsets have been shuffled
+	FlagOrdered  uint32 = 0x2 // offsets are sorted alphabetically
+	FlagRotated  uint32 = 0x4 // text is rot13 encoded
+	FlagComments uint32 = 0x8 // lines starting with two delimiters are comments
+)
+
+// Version is the strfile format version written by BuildIndex.
+const Version = 2
+
+// HeaderSize is the size in bytes of an encoded Header.
+const HeaderSize = 24
+
+// DefaultDelimiter separates fortunes in most databases.
+const DefaultDelimiter = '%'
+
+var (
+	// ErrBadHeader is returned when a .dat file is too short or its header
+	// is inconsistent.
+	ErrBadHeader = errors.New("fortunefile: malformed strfile header")
+
+	// ErrOutOfRange is returned when a fortune index is outside the table.
+	ErrOutOfRange = errors.New("fortunefile: fortune index out of range")
+)
+
+// Header mirrors the STRFILE struct at the start of every .dat file.
+type Header struct {
+	Version   uint32
+	NumStr    uint32
+	LongLen   uint32
+	ShortLen  uint32
+	Flags     uint32
+	Delimiter byte
+}
+
+// Random reports whether the offsets were shuffled.
+func (h Header) Random() bool { return h.Flags&FlagRandom != 0 }
+
+// Ordered reports whether the offsets were sorted alphabetically.
+func (h Header) Ordered() bool { return h.Flags&FlagOrdered != 0 }
+
+// Rotated reports whether the text is rot13 encoded.
+func (h Header) Rotated() bool { return h.Flags&FlagRotated != 0 }
+
+// Index is a decoded strfile index: the header and its offset table.
+// Offsets holds NumStr+1 entries; the last one is the end of the text file.
+type Index struct {
+	Header
+	Offsets []uint32
+}
+
+// ReadIndex decodes a strfile index from r.
+func ReadIndex(r io.Reader) (*Index, error) {
+	var raw [HeaderSize]byte
+	if _, err := io.ReadFull(r, raw[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadHeader, err)
+	}
+
+	h := Header{
+		Version:   binary.BigEndian.Uint32(raw[0:4]),
+		NumStr:    binary.BigEndian.Uint32(raw[4:8]),
+		LongLen:   binary.BigEndian.Uint32(raw[8:12]),
+		ShortLen:  binary.BigEndian.Uint32(raw[12:16]),
+		Flags:     binary.BigEndian.Uint32(raw[16:20]),
+		Delimiter: raw[20],
+	}
+	if h.Version == 0 || h.Version > Version {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadHeader, h.Version)
+	}
+	if h.Delimiter == 0 {
+		h.Delimiter = DefaultDelimiter
+	}
+
+	// Guard against absurd counts before allocating the table.
+	const maxStrings = 1 << 24
+	if h.NumStr > maxStrings {
+		return nil, fmt.Errorf("%w: %d strings", ErrBadHeader, h.NumStr)
+	}
+
+	offsets := make([]uint32, h.NumStr+1)
+	if err := binary.Read(r, binary.BigEndian, offsets); err != nil {
+		// Some strfile versions omit the trailing offset of empty files.
+		if errors.Is(err, io.EOF) && h.NumStr == 0 {
+			return &Index{Header: h, Offsets: offsets}, nil
+		}
+		return nil, fmt.Errorf("%w: reading offsets: %v", ErrBadHeader, err)
+	}
+
+	return &Index{Header: h, Offsets: offsets}, nil
+}
+
+// LoadIndex reads the strfile index stored at path.
+func LoadIndex(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	idx, err := ReadIndex(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return idx, nil
+}
+
+// WriteTo encodes the index in strfile format.
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	var raw [HeaderSize]byte
+	binary.BigEndian.PutUint32(raw[0:4], idx.Version)
+	binary.BigEndian.PutUint32(raw[4:8], idx.NumStr)
+	binary.BigEndian.PutUint32(raw[8:12], idx.LongLen)
+	binary.BigEndian.PutUint32(raw[12:16], idx.ShortLen)
+	binary.BigEndian.PutUint32(raw[16:20], idx.Flags)
+	raw[20] = idx.Delimiter
+
+	n, err := w.Write(raw[:])
+	if err != nil {
+		return int64(n), err
+	}
+	if err := binary.Write(w, binary.BigEndian, idx.Offsets); err != nil {
+		return int64(n), err
+	}
+	return int64(n + 4*len(idx.Offsets)), nil
+}
+
+// BuildIndex scans fortune text from r and builds its index, like strfile(1).
+// With FlagOrdered the offsets are sorted alphabetically, ignoring case and
+// leading punctuation; with FlagRandom they are shuffled using rng, which may
+// be nil for the default source. FlagRotated and FlagComments are recorded
+// in the header but do not change how the text is scanned.
+func BuildIndex(r io.Reader, delim byte, flags uint32, rng *rand.Rand) (*Index, error) {
+	if delim == 0 {
+		delim = DefaultDelimiter
+	}
+
+	idx := &Index{Header: Header{
+		Version:   Version,
+		Flags:     flags,
+		Delimiter: delim,
+		ShortLen:  ^uint32(0),
+	}}
+
+	var (
+		br      = bufio.NewReader(r)
+		pos     uint32
+		start   uint32
+		offsets []uint32
+		keys    []string
+		first   []byte
+	)
+
+	record := func(end uint32) {
+		length := end - start
+		if length == 0 {
+			return
+		}
+		offsets = append(offsets, start)
+		keys = append(keys, sortKey(first))
+		if length > idx.LongLen {
+			idx.LongLen = length
+		}
+		if length < idx.ShortLen {
+			idx.ShortLen = length
+		}
+	}
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if isDelimiterLine(line, delim) {
+				record(pos)
+				pos += uint32(len(line))
+				start = pos
+				first = nil
+			} else {
+				if first == nil {
+					first = line
+				}
+				pos += uint32(len(line))
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	record(pos)
+
+	if len(offsets) == 0 {
+		idx.ShortLen = 0
+	}
+
+	switch {
+	case flags&FlagOrdered != 0:
+		sort.Stable(byKey{offsets, keys})
+	case flags&FlagRandom != 0:
+		shuffle := rand.Shuffle
+		if rng != nil {
+			shuffle = rng.Shuffle
+		}
+		shuffle(len(offsets), func(i, j int) {
+			offsets[i], offsets[j] = offsets[j], offsets[i]
+		})
+	}
+
+	idx.NumStr = uint32(len(offsets))
+	idx.Offsets = append(offsets, pos)
+	return idx, nil
+}
+
+// isDelimiterLine reports whether line consists solely of the delimiter.
+func isDelimiterLine(line []byte, delim byte) bool {
+	line = bytes.TrimRight(line, "\r\n")
+	return len(line) == 1 && line[0] == delim
+}
+
+// sortKey normalises the first line of a fortune for FlagOrdered sorting.
+func sortKey(line []byte) string {
+	s := strings.TrimLeftFunc(string(line), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.ToLower(s)
+}
+
+type byKey struct {
+	offsets []uint32
+	keys    []string
+}
+
+func (b byKey) Len() int           { return len(b.offsets) }
+func (b byKey) Less(i, j int) bool { return b.keys[i] < b.keys[j] }
+func (b byKey) Swap(i, j int) {
+	b.offsets[i], b.offsets[j] = b.offsets[j], b.offsets[i]
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+}
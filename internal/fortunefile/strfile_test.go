@@ -0,0 +1,86 @@
+package fortunefile
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleText = "Zebras are striped.\n%\nApples fall.\nNewton noticed.\n%\n\"Quoted\" maxim.\n%\n"
+
+func TestBuildIndex(t *testing.T) {
+	idx, err := BuildIndex(strings.NewReader(sampleText), '%', 0, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(Version), idx.Version)
+	assert.Equal(t, uint32(3), idx.NumStr)
+	assert.Equal(t, byte('%'), idx.Delimiter)
+	assert.Equal(t, []uint32{0, 22, 53, uint32(len(sampleText))}, idx.Offsets)
+	assert.Equal(t, uint32(len("Apples fall.\nNewton noticed.\n")), idx.LongLen)
+	assert.Equal(t, uint32(len("\"Quoted\" maxim.\n")), idx.ShortLen)
+}
+
+func TestBuildIndex_SkipsEmptyEntries(t *testing.T) {
+	idx, err := BuildIndex(strings.NewReader("%\none\n%\n%\ntwo"), '%', 0, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(2), idx.NumStr)
+}
+
+func TestBuildIndex_Flags(t *testing.T) {
+	t.Run("Ordered", func(t *testing.T) {
+		idx, err := BuildIndex(strings.NewReader(sampleText), '%', FlagOrdered, nil)
+		require.NoError(t, err)
+
+		// "Apples", "Quoted" (leading punctuation ignored), "Zebras".
+		assert.Equal(t, []uint32{22, 53, 0, uint32(len(sampleText))}, idx.Offsets)
+		assert.True(t, idx.Ordered())
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		idx, err := BuildIndex(strings.NewReader(sampleText), '%', FlagRandom, rand.New(rand.NewSource(1)))
+		require.NoError(t, err)
+
+		assert.ElementsMatch(t, []uint32{0, 22, 53}, idx.Offsets[:3])
+		assert.Equal(t, uint32(len(sampleText)), idx.Offsets[3], "end offset must stay last")
+		assert.True(t, idx.Random())
+	})
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	idx, err := BuildIndex(strings.NewReader(sampleText), '%', FlagRotated, nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	n, err := idx.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(HeaderSize+4*4), n)
+	assert.Equal(t, int(n), buf.Len())
+
+	decoded, err := ReadIndex(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, idx, decoded)
+}
+
+func TestReadIndex_Malformed(t *testing.T) {
+	testCases := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Empty", data: nil},
+		{name: "Truncated header", data: make([]byte, 10)},
+		{name: "Zero version", data: make([]byte, HeaderSize)},
+		{name: "Missing offsets", data: append([]byte{0, 0, 0, 2, 0, 0, 0, 5}, make([]byte, 16)...)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ReadIndex(bytes.NewReader(tc.data))
+			assert.ErrorIs(t, err, ErrBadHeader)
+		})
+	}
+}
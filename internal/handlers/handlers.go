@@ -2,59 +2,193 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"fortune-api/internal/service"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 )
 
+// Defaults for Limits.
+const (
+	// DefaultMaxCount is the largest count accepted by GetFortune and
+	// GetSimilarFortunes.
+	DefaultMaxCount = 10
+	// DefaultMaxSearchLimit is the largest page SearchFortunes returns.
+	DefaultMaxSearchLimit = 100
+)
+
+// DefaultSearchLimit is the page size of a search that doesn't give one.
+const DefaultSearchLimit = 20
+
+// DefaultSimilarCount is how many similar fortunes are returned when the
+// count parameter is not given.
+const DefaultSimilarCount = 5
+
+// Limits caps what a single request may ask for. Zero fields take their
+// defaults.
+type Limits struct {
+	MaxCount       int
+	MaxSearchLimit int
+}
+
 type Handler struct {
 	// Depend on the interface, not the concrete type.
 	fortuneService service.FortuneServiceInterface
 	logger         *zap.Logger
-}
+	limits         Limits
 
-type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
+	// now is the clock used for the fortune of the day.
+	now func() time.Time
 }
 
 // NewHandler now accepts the interface.
-func NewHandler(fortuneService service.FortuneServiceInterface, logger *zap.Logger) *Handler {
+func NewHandler(fortuneService service.FortuneServiceInterface, logger *zap.Logger, limits Limits) *Handler {
+	if limits.MaxCount <= 0 {
+		limits.MaxCount = DefaultMaxCount
+	}
+	if limits.MaxSearchLimit <= 0 {
+		limits.MaxSearchLimit = DefaultMaxSearchLimit
+	}
 	return &Handler{
 		fortuneService: fortuneService,
 		logger:         logger,
+		limits:         limits,
+		now:            time.Now,
 	}
 }
 
 func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	response := map[string]any{
 		"status":  "healthy",
 		"service": "fortune-api",
-	})
+	}
+	if statser, ok := h.fortuneService.(service.IndexStatser); ok {
+		if stats, built := statser.IndexStats(); built {
+			response["index"] = stats
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
+// GetFortune returns one fortune, or a list of distinct fortunes when the
+// count parameter is given.
 func (h *Handler) GetFortune(w http.ResponseWriter, r *http.Request) {
-	opts := h.parseFortuneOptions(r)
+	verr := &service.ValidationError{}
+	opts, err := h.parseFortuneOptions(r)
+	verr.Merge(err)
+	count, hasCount := h.parseCount(r.URL.Query(), verr)
+	if err := verr.ErrOrNil(); err != nil {
+		h.writeServiceError(w, r, err, "Invalid parameter")
+		return
+	}
+
+	if hasCount {
+		fortunes, err := h.fortuneService.GetFortunes(r.Context(), opts, count)
+		if err != nil {
+			h.writeServiceError(w, r, err, "Failed to get fortunes")
+			return
+		}
+		h.writeJSONResponse(w, http.StatusOK, fortunes)
+		return
+	}
+
+	fortune, err := h.fortuneService.GetFortune(r.Context(), opts)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get fortune")
+		return
+	}
+
+	h.writeJSONResponse(w, http.StatusOK, fortune)
+}
+
+// GetDailyFortune serves the same fortune to every caller for a whole
+// period. The selection is seeded from the period and the options, and the
+// response may be cached until the period ends.
+func (h *Handler) GetDailyFortune(w http.ResponseWriter, r *http.Request) {
+	verr := &service.ValidationError{}
+	opts, err := h.parseFortuneOptions(r)
+	verr.Merge(err)
+	loc, period := parseDailyOptions(r.URL.Query(), verr)
+	if err := verr.ErrOrNil(); err != nil {
+		h.writeServiceError(w, r, err, "Invalid parameter")
+		return
+	}
+
+	now := h.now().In(loc)
+	label, _, end := period.Bucket(now)
+	opts.Seed = service.DailySeed(label, opts)
+
+	fortune, err := h.fortuneService.GetFortune(r.Context(), opts)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get fortune")
+		return
+	}
 
-	fortune, err := h.fortuneService.GetFortune(opts)
+	maxAge := max(int(math.Ceil(end.Sub(now).Seconds())), 0)
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+	w.Header().Set("Expires", end.UTC().Format(http.TimeFormat))
+	h.writeJSONResponse(w, http.StatusOK, fortune)
+}
+
+func (h *Handler) GetFortuneByID(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	fortune, err := h.fortuneService.GetFortuneByID(r.Context(), id)
 	if err != nil {
-		h.logger.Error("Failed to get fortune", zap.Error(err))
-		h.writeErrorResponse(w, http.StatusInternalServerError, "Failed to get fortune", err.Error())
+		h.writeServiceError(w, r, err, "Failed to get fortune")
 		return
 	}
 
 	h.writeJSONResponse(w, http.StatusOK, fortune)
 }
 
+// GetSimilarFortunes returns the fortunes most like the one with the given
+// ID, by the words they share. count sets how many, up to the configured
+// maximum, and the selection and length parameters of GetFortune narrow
+// where they come from.
+func (h *Handler) GetSimilarFortunes(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	verr := &service.ValidationError{}
+	opts, err := h.parseFortuneOptions(r)
+	verr.Merge(err)
+	count, hasCount := h.parseCount(r.URL.Query(), verr)
+	if err := verr.ErrOrNil(); err != nil {
+		h.writeServiceError(w, r, err, "Invalid parameter")
+		return
+	}
+	if !hasCount {
+		count = min(DefaultSimilarCount, h.limits.MaxCount)
+	}
+
+	similar, err := h.fortuneService.SimilarFortunes(r.Context(), id, opts, count)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to find similar fortunes")
+		return
+	}
+
+	h.writeJSONResponse(w, http.StatusOK, similar)
+}
+
 func (h *Handler) ListFiles(w http.ResponseWriter, r *http.Request) {
-	files, err := h.fortuneService.ListFiles()
+	query, err := parseFileQuery(r)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Invalid parameter")
+		return
+	}
+
+	files, err := h.fortuneService.ListFiles(r.Context(), query)
 	if err != nil {
-		h.logger.Error("Failed to list fortune files", zap.Error(err))
-		h.writeErrorResponse(w, http.StatusInternalServerError, "Failed to list files", err.Error())
+		h.writeServiceError(w, r, err, "Failed to list files")
 		return
 	}
 
@@ -66,44 +200,121 @@ func (h *Handler) ListFiles(w http.ResponseWriter, r *http.Request) {
 	h.writeJSONResponse(w, http.StatusOK, response)
 }
 
+func (h *Handler) GetFile(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	file, err := h.fortuneService.GetFile(r.Context(), name)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get file")
+		return
+	}
+
+	h.writeJSONResponse(w, http.StatusOK, file)
+}
+
+func (h *Handler) GetProbabilities(w http.ResponseWriter, r *http.Request) {
+	opts, err := h.parseFortuneOptions(r)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Invalid parameter")
+		return
+	}
+
+	probs, err := h.fortuneService.Probabilities(r.Context(), opts)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to compute probabilities")
+		return
+	}
+
+	response := map[string]any{
+		"files": probs,
+		"count": len(probs),
+	}
+
+	h.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// SearchFortunes answers queries in the search language given in q from the
+// search index, and regular expressions given in pattern by scanning every
+// fortune. With fuzzy, query terms also match misspellings. Results come a
+// page at a time, or as a stream of every match when the client accepts
+// newline-delimited JSON.
 func (h *Handler) SearchFortunes(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
 	pattern := r.URL.Query().Get("pattern")
-	if pattern == "" {
-		h.writeErrorResponse(w, http.StatusBadRequest, "Missing required parameter", "pattern parameter is required")
+	if query == "" && pattern == "" {
+		h.writeProblem(w, r, http.StatusBadRequest, problemMissingParameter, "Missing required parameter", "q or pattern parameter is required")
 		return
 	}
 
-	opts := h.parseFortuneOptions(r)
+	verr := &service.ValidationError{}
+	opts, err := h.parseFortuneOptions(r)
+	verr.Merge(err)
+	page := h.parseSearchPage(r.URL.Query(), verr)
+	stream := acceptsNDJSON(r)
+	if stream && !r.URL.Query().Has("limit") {
+		page.Limit = 0
+	}
+	if query != "" && pattern != "" {
+		verr.Add("q", query, service.CodeConflict, "cannot be combined with pattern")
+	}
+	if pattern != "" && opts.Fuzzy {
+		verr.Add("fuzzy", "true", service.CodeConflict, "only applies to q, not pattern")
+	}
+	if stream && len(page.Facets) > 0 {
+		verr.Add("facets", r.URL.Query().Get("facets"), service.CodeConflict, "cannot be streamed")
+	}
+	if err := verr.ErrOrNil(); err != nil {
+		h.writeServiceError(w, r, err, "Invalid parameter")
+		return
+	}
+
+	if stream {
+		h.streamSearch(w, r, query, pattern, opts, page)
+		return
+	}
 
-	results, err := h.fortuneService.SearchFortunes(pattern, opts)
+	var results *service.SearchResponse
+	if query != "" {
+		results, err = h.fortuneService.QueryFortunes(r.Context(), query, opts, page)
+	} else {
+		results, err = h.fortuneService.SearchFortunes(r.Context(), pattern, opts, page)
+	}
 	if err != nil {
-		h.logger.Error("Failed to search fortunes", zap.Error(err))
-		h.writeErrorResponse(w, http.StatusInternalServerError, "Search failed", err.Error())
+		h.writeServiceError(w, r, err, "Search failed")
 		return
 	}
 
 	h.writeJSONResponse(w, http.StatusOK, results)
 }
 
-func (h *Handler) parseFortuneOptions(r *http.Request) service.FortuneOptions {
+// parseFortuneOptions reads the fortune options from the query string. Every
+// malformed or contradictory value is collected into a single
+// *service.ValidationError rather than being silently dropped.
+func (h *Handler) parseFortuneOptions(r *http.Request) (service.FortuneOptions, error) {
 	query := r.URL.Query()
+	verr := &service.ValidationError{}
 
 	opts := service.FortuneOptions{
-		All:        query.Get("all") == "true",
-		ShowCookie: query.Get("show_cookie") == "true",
-		Equal:      query.Get("equal") == "true",
-		Long:       query.Get("long") == "true",
-		Short:      query.Get("short") == "true",
-		IgnoreCase: query.Get("ignore_case") == "true",
-		Wait:       query.Get("wait") == "true",
+		All:        parseBool(query, "all", verr),
+		ShowCookie: parseBool(query, "show_cookie", verr),
+		Equal:      parseBool(query, "equal", verr),
+		Long:       parseBool(query, "long", verr),
+		Short:      parseBool(query, "short", verr),
+		IgnoreCase: parseBool(query, "ignore_case", verr),
+		Wait:       parseBool(query, "wait", verr),
+		Fuzzy:      parseBool(query, "fuzzy", verr),
 		Pattern:    query.Get("pattern"),
+		Match:      query.Get("match"),
+		Seed:       query.Get("seed"),
+		Offensive:  service.OffensiveMode(strings.ToLower(query.Get("offensive"))),
 	}
 
-	if lengthStr := query.Get("length"); lengthStr != "" {
-		if length, err := strconv.Atoi(lengthStr); err == nil {
-			opts.Length = length
-		}
-	}
+	opts.Length = parsePositiveInt(query, "length", verr)
+	opts.FuzzyDistance = parsePositiveInt(query, "distance", verr)
+	opts.MinLength = parsePositiveInt(query, "min_length", verr)
+	opts.MaxLength = parsePositiveInt(query, "max_length", verr)
+	opts.MaxLines = parsePositiveInt(query, "max_lines", verr)
+	opts.MaxWidth = parsePositiveInt(query, "max_width", verr)
 
 	if filesStr := query.Get("files"); filesStr != "" {
 		opts.Files = strings.Split(filesStr, ",")
@@ -113,22 +324,166 @@ func (h *Handler) parseFortuneOptions(r *http.Request) service.FortuneOptions {
 		opts.Percentages = strings.Split(percentagesStr, ",")
 	}
 
-	return opts
+	if excludeStr := query.Get("exclude_files"); excludeStr != "" {
+		opts.ExcludeFiles = strings.Split(excludeStr, ",")
+	}
+
+	if excludeStr := query.Get("exclude_ids"); excludeStr != "" {
+		opts.ExcludeIDs = strings.Split(excludeStr, ",")
+	}
+
+	verr.Merge(opts.Validate())
+	return opts, verr.ErrOrNil()
 }
 
-func (h *Handler) writeJSONResponse(w http.ResponseWriter, statusCode int, data any) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
+// parseCount reads the count parameter, which must lie between 1 and the
+// configured maximum. It reports whether count was given at all.
+func (h *Handler) parseCount(query url.Values, verr *service.ValidationError) (int, bool) {
+	if !query.Has("count") {
+		return 0, false
+	}
 
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		h.logger.Error("Failed to encode JSON response", zap.Error(err))
+	value := query.Get("count")
+	count, err := strconv.Atoi(value)
+	switch {
+	case err != nil:
+		verr.Add("count", value, service.CodeInvalidInteger, "must be a whole number")
+	case count < 1 || count > h.limits.MaxCount:
+		verr.Add("count", value, service.CodeOutOfRange, fmt.Sprintf("must be between 1 and %d", h.limits.MaxCount))
 	}
+	return count, true
 }
 
-func (h *Handler) writeErrorResponse(w http.ResponseWriter, statusCode int, error, message string) {
-	response := ErrorResponse{
-		Error:   error,
-		Message: message,
+// parseSearchPage reads the sort, limit, offset, cursor and facets
+// parameters of a search. The limit defaults to DefaultSearchLimit and may
+// not exceed the configured maximum; facets is a comma-separated list.
+func (h *Handler) parseSearchPage(query url.Values, verr *service.ValidationError) service.SearchPage {
+	page := service.SearchPage{
+		Sort:   strings.ToLower(query.Get("sort")),
+		Limit:  min(DefaultSearchLimit, h.limits.MaxSearchLimit),
+		Cursor: query.Get("cursor"),
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		switch {
+		case err != nil:
+			verr.Add("limit", value, service.CodeInvalidInteger, "must be a whole number")
+		case limit < 1 || limit > h.limits.MaxSearchLimit:
+			verr.Add("limit", value, service.CodeOutOfRange, fmt.Sprintf("must be between 1 and %d", h.limits.MaxSearchLimit))
+		default:
+			page.Limit = limit
+		}
+	}
+
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		switch {
+		case err != nil:
+			verr.Add("offset", value, service.CodeInvalidInteger, "must be a whole number")
+		case offset < 0:
+			verr.Add("offset", value, service.CodeOutOfRange, "must not be negative")
+		default:
+			page.Offset = offset
+		}
 	}
-	h.writeJSONResponse(w, statusCode, response)
+
+	if value := query.Get("facets"); value != "" {
+		for _, facet := range strings.Split(value, ",") {
+			page.Facets = append(page.Facets, strings.ToLower(strings.TrimSpace(facet)))
+		}
+	}
+
+	verr.Merge(page.Validate())
+	return page
+}
+
+// parseDailyOptions reads the tz and period parameters of the fortune of
+// the day. They default to UTC and a day.
+func parseDailyOptions(query url.Values, verr *service.ValidationError) (*time.Location, service.Period) {
+	loc := time.UTC
+	if tz := query.Get("tz"); tz != "" {
+		l, err := time.LoadLocation(tz)
+		if err != nil || tz == "Local" {
+			verr.Add("tz", tz, service.CodeInvalidValue, "is not an IANA time zone name")
+		} else {
+			loc = l
+		}
+	}
+
+	period := service.PeriodDay
+	if p := query.Get("period"); p != "" {
+		period = service.Period(strings.ToLower(p))
+		if !period.Valid() {
+			verr.Add("period", p, service.CodeInvalidValue, "must be one of hour, day, week")
+		}
+	}
+	return loc, period
+}
+
+// parseFileQuery reads the prefix, sort and order parameters of a file
+// listing. The sort key itself is checked by the service.
+func parseFileQuery(r *http.Request) (service.FileQuery, error) {
+	query := r.URL.Query()
+	verr := &service.ValidationError{}
+
+	q := service.FileQuery{
+		Prefix: query.Get("prefix"),
+		Sort:   strings.ToLower(query.Get("sort")),
+	}
+
+	switch order := query.Get("order"); strings.ToLower(order) {
+	case "", "asc":
+	case "desc":
+		q.Descending = true
+	default:
+		verr.Add("order", order, service.CodeInvalidValue, "must be one of asc, desc")
+	}
+
+	verr.Merge(q.Validate())
+	return q, verr.ErrOrNil()
+}
+
+// parseBool reads a boolean query parameter. It accepts 1/0, true/false,
+// yes/no and on/off in any case; a parameter given without a value, as in
+// "?short", counts as true. Anything else is recorded in verr.
+func parseBool(query url.Values, key string, verr *service.ValidationError) bool {
+	if !query.Has(key) {
+		return false
+	}
+
+	value := query.Get(key)
+	switch strings.ToLower(value) {
+	case "", "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	}
+
+	verr.Add(key, value, service.CodeInvalidBoolean, "must be one of true/false, 1/0, yes/no")
+	return false
+}
+
+// parsePositiveInt reads an optional positive whole number, returning zero
+// if it is absent or invalid.
+func parsePositiveInt(query url.Values, key string, verr *service.ValidationError) int {
+	value := query.Get(key)
+	if value == "" {
+		return 0
+	}
+
+	n, err := strconv.Atoi(value)
+	switch {
+	case err != nil:
+		verr.Add(key, value, service.CodeInvalidInteger, "must be a whole number")
+	case n <= 0:
+		verr.Add(key, value, service.CodeOutOfRange, "must be a positive number")
+	default:
+		return n
+	}
+	return 0
+}
+
+func (h *Handler) writeJSONResponse(w http.ResponseWriter, statusCode int, data any) {
+	h.writeJSON(w, statusCode, "application/json", data)
 }
@@ -1,13 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"fortune-api/internal/service"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.uber.org/zap"
@@ -19,35 +24,96 @@ type MockFortuneService struct {
 	mock.Mock
 }
 
-func (m *MockFortuneService) GetFortune(opts service.FortuneOptions) (*service.FortuneResponse, error) {
-	args := m.Called(opts)
+func (m *MockFortuneService) GetFortune(ctx context.Context, opts service.FortuneOptions) (*service.FortuneResponse, error) {
+	args := m.Called(ctx, opts)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*service.FortuneResponse), args.Error(1)
 }
 
-func (m *MockFortuneService) ListFiles() ([]string, error) {
-	args := m.Called()
+func (m *MockFortuneService) GetFortunes(ctx context.Context, opts service.FortuneOptions, count int) (*service.FortunesResponse, error) {
+	args := m.Called(ctx, opts, count)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).([]string), args.Error(1)
+	return args.Get(0).(*service.FortunesResponse), args.Error(1)
 }
 
-func (m *MockFortuneService) SearchFortunes(pattern string, opts service.FortuneOptions) (*service.SearchResponse, error) {
-	args := m.Called(pattern, opts)
+func (m *MockFortuneService) GetFortuneByID(ctx context.Context, id string) (*service.FortuneResponse, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.FortuneResponse), args.Error(1)
+}
+
+func (m *MockFortuneService) ListFiles(ctx context.Context, query service.FileQuery) ([]service.FileInfo, error) {
+	args := m.Called(ctx, query)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]service.FileInfo), args.Error(1)
+}
+
+func (m *MockFortuneService) GetFile(ctx context.Context, name string) (*service.FileInfo, error) {
+	args := m.Called(ctx, name)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.FileInfo), args.Error(1)
+}
+
+func (m *MockFortuneService) Probabilities(ctx context.Context, opts service.FortuneOptions) ([]service.FileProbability, error) {
+	args := m.Called(ctx, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]service.FileProbability), args.Error(1)
+}
+
+func (m *MockFortuneService) SearchFortunes(ctx context.Context, pattern string, opts service.FortuneOptions, page service.SearchPage) (*service.SearchResponse, error) {
+	args := m.Called(ctx, pattern, opts, page)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.SearchResponse), args.Error(1)
+}
+
+func (m *MockFortuneService) QueryFortunes(ctx context.Context, query string, opts service.FortuneOptions, page service.SearchPage) (*service.SearchResponse, error) {
+	args := m.Called(ctx, query, opts, page)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*service.SearchResponse), args.Error(1)
 }
 
+func (m *MockFortuneService) SimilarFortunes(ctx context.Context, id string, opts service.FortuneOptions, count int) (*service.SimilarResponse, error) {
+	args := m.Called(ctx, id, opts, count)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.SimilarResponse), args.Error(1)
+}
+
+// indexedMockService is a MockFortuneService that also reports index stats.
+type indexedMockService struct {
+	*MockFortuneService
+	stats service.IndexStats
+}
+
+func (m indexedMockService) IndexStats() (service.IndexStats, bool) {
+	return m.stats, true
+}
+
+// defaultPage is the page a search gets when it asks for none.
+var defaultPage = service.SearchPage{Limit: DefaultSearchLimit}
+
 // setupTestHandler initializes a handler with a mock service for testing.
 func setupTestHandler() (*Handler, *MockFortuneService) {
 	mockService := new(MockFortuneService)
 	logger := zap.NewNop() // Use a no-op logger for tests to keep output clean.
-	handler := NewHandler(mockService, logger)
+	handler := NewHandler(mockService, logger, Limits{})
 	return handler, mockService
 }
 
@@ -68,12 +134,32 @@ func TestHealthCheck(t *testing.T) {
 	assert.Equal(t, "fortune-api", response["service"])
 }
 
+func TestHealthCheck_IndexStats(t *testing.T) {
+	stats := service.IndexStats{Files: 3, Documents: 120, Terms: 900, SizeBytes: 4096, BuildMillis: 1.5}
+	handler := NewHandler(indexedMockService{MockFortuneService: new(MockFortuneService), stats: stats}, zap.NewNop(), Limits{})
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	rr := httptest.NewRecorder()
+
+	handler.HealthCheck(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Status string             `json:"status"`
+		Index  service.IndexStats `json:"index"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "healthy", response.Status)
+	assert.Equal(t, stats, response.Index)
+}
+
 func TestGetFortune_Success(t *testing.T) {
 	handler, mockService := setupTestHandler()
 
 	expectedFortune := &service.FortuneResponse{Fortune: "Your future is bright."}
 	// We expect GetFortune to be called once with any FortuneOptions and return our expected fortune.
-	mockService.On("GetFortune", mock.AnythingOfType("service.FortuneOptions")).Return(expectedFortune, nil)
+	mockService.On("GetFortune", mock.Anything, mock.AnythingOfType("service.FortuneOptions")).Return(expectedFortune, nil)
 
 	req := httptest.NewRequest("GET", "/fortune?short=true", nil)
 	rr := httptest.NewRecorder()
@@ -93,7 +179,7 @@ func TestGetFortune_ServiceError(t *testing.T) {
 	handler, mockService := setupTestHandler()
 
 	// Configure the mock to return an error.
-	mockService.On("GetFortune", mock.AnythingOfType("service.FortuneOptions")).Return(nil, errors.New("something went wrong"))
+	mockService.On("GetFortune", mock.Anything, mock.AnythingOfType("service.FortuneOptions")).Return(nil, errors.New("something went wrong"))
 
 	req := httptest.NewRequest("GET", "/fortune", nil)
 	rr := httptest.NewRecorder()
@@ -103,17 +189,493 @@ func TestGetFortune_ServiceError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 	mockService.AssertExpectations(t)
 
-	var errResponse ErrorResponse
+	var errResponse Problem
 	err := json.Unmarshal(rr.Body.Bytes(), &errResponse)
 	assert.NoError(t, err)
-	assert.Equal(t, "Failed to get fortune", errResponse.Error)
+	assert.Equal(t, problemInternal, errResponse.Type)
+	assert.Equal(t, "Failed to get fortune", errResponse.Title)
+	assert.Equal(t, http.StatusInternalServerError, errResponse.Status)
+	assert.NotContains(t, rr.Body.String(), "something went wrong", "internal error text must not leak")
+	assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+}
+
+func TestGetFortune_Timeout(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	mockService.On("GetFortune", mock.Anything, mock.AnythingOfType("service.FortuneOptions")).
+		Return(nil, fmt.Errorf("fortune command aborted: %w", context.DeadlineExceeded))
+
+	req := httptest.NewRequest("GET", "/fortune", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetFortune(rr, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+	mockService.AssertExpectations(t)
+
+	var errResponse Problem
+	err := json.Unmarshal(rr.Body.Bytes(), &errResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, "Request timed out", errResponse.Title)
+}
+
+func TestGetFortune_InvalidFile(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	verr := &service.ValidationError{Errors: []service.FieldError{
+		{Field: "files", Value: "../../etc/passwd", Message: "is not a valid fortune file name"},
+	}}
+	mockService.On("GetFortune", mock.Anything, mock.AnythingOfType("service.FortuneOptions")).Return(nil, verr)
+
+	req := httptest.NewRequest("GET", "/fortune?files=../../etc/passwd", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetFortune(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	mockService.AssertExpectations(t)
+
+	var errResponse Problem
+	err := json.Unmarshal(rr.Body.Bytes(), &errResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, "Invalid parameter", errResponse.Title)
+	assert.Contains(t, errResponse.Detail, "../../etc/passwd")
+}
+
+func TestGetFortune_InvalidOptions(t *testing.T) {
+	testCases := []struct {
+		name  string
+		query string
+		codes []string
+	}{
+		{name: "Length not a number", query: "length=abc", codes: []string{service.CodeInvalidInteger}},
+		{name: "Length zero", query: "length=0", codes: []string{service.CodeOutOfRange}},
+		{name: "Bad boolean", query: "all=maybe", codes: []string{service.CodeInvalidBoolean}},
+		{name: "Long and short", query: "long=true&short=1", codes: []string{service.CodeConflict}},
+		{name: "Percentages over 100", query: "files=a,b&percentages=60,50", codes: []string{service.CodeOutOfRange}},
+		{name: "Percentages under 100 for every file", query: "files=a&percentages=50", codes: []string{service.CodeOutOfRange}},
+		{name: "Percentages of 100 with files left", query: "files=a,b&percentages=100", codes: []string{service.CodeOutOfRange}},
+		{name: "Unknown offensive mode", query: "offensive=sometimes", codes: []string{service.CodeInvalidValue}},
+		{name: "All with offensive only", query: "all=true&offensive=only", codes: []string{service.CodeConflict}},
+		{name: "More percentages than files", query: "files=a&percentages=10,20", codes: []string{service.CodeTooManyValues}},
+		{name: "Count not a number", query: "count=lots", codes: []string{service.CodeInvalidInteger}},
+		{name: "Count zero", query: "count=0", codes: []string{service.CodeOutOfRange}},
+		{name: "Count over maximum", query: "count=11", codes: []string{service.CodeOutOfRange}},
+		{name: "Match with pattern", query: "match=cat&pattern=dog", codes: []string{service.CodeConflict}},
+		{name: "Max width not a number", query: "max_width=wide", codes: []string{service.CodeInvalidInteger}},
+		{name: "Max lines zero", query: "max_lines=0", codes: []string{service.CodeOutOfRange}},
+		{name: "Malformed excluded ID", query: "exclude_ids=wisdom", codes: []string{service.CodeInvalidValue}},
+		{name: "File named and excluded", query: "files=wisdom&exclude_files=wisdom", codes: []string{service.CodeConflict}},
+		{name: "Min length over max length", query: "min_length=50&max_length=10", codes: []string{service.CodeConflict}},
+		{name: "Seed too long", query: "seed=" + strings.Repeat("x", 65), codes: []string{service.CodeOutOfRange}},
+		{
+			name:  "All problems listed",
+			query: "length=abc&equal=2&long=yes&short=on",
+			codes: []string{service.CodeInvalidInteger, service.CodeInvalidBoolean, service.CodeConflict},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, mockService := setupTestHandler()
+
+			req := httptest.NewRequest("GET", "/fortune?"+tc.query, nil)
+			rr := httptest.NewRecorder()
+
+			handler.GetFortune(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			mockService.AssertNotCalled(t, "GetFortune", mock.Anything, mock.Anything)
+
+			var errResponse Problem
+			err := json.Unmarshal(rr.Body.Bytes(), &errResponse)
+			assert.NoError(t, err)
+			var codes []string
+			for _, d := range errResponse.Errors {
+				codes = append(codes, d.Code)
+			}
+			assert.ElementsMatch(t, tc.codes, codes)
+		})
+	}
+}
+
+func TestGetFortune_SizeLimits(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	expected := service.FortuneOptions{MinLength: 20, MaxLength: 80, MaxLines: 2, MaxWidth: 40}
+	mockService.On("GetFortune", mock.Anything, expected).Return(&service.FortuneResponse{Fortune: "ok"}, nil)
+
+	req := httptest.NewRequest("GET", "/fortune?min_length=20&max_length=80&max_lines=2&max_width=40", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetFortune(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestGetFortune_Exclusions(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	expected := service.FortuneOptions{
+		ExcludeFiles: []string{"zippy", "linux"},
+		ExcludeIDs:   []string{"wisdom-3f2a9c0d1b7e4a65"},
+	}
+	mockService.On("GetFortune", mock.Anything, expected).Return(&service.FortuneResponse{Fortune: "ok"}, nil)
+
+	req := httptest.NewRequest("GET", "/fortune?exclude_files=zippy,linux&exclude_ids=wisdom-3f2a9c0d1b7e4a65", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetFortune(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestGetFortune_BooleanForms(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	expected := service.FortuneOptions{All: true, Equal: true, ShowCookie: true, Long: false, IgnoreCase: false}
+	mockService.On("GetFortune", mock.Anything, expected).Return(&service.FortuneResponse{Fortune: "ok"}, nil)
+
+	req := httptest.NewRequest("GET", "/fortune?all=1&equal=YES&show_cookie&long=no&ignore_case=0", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetFortune(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestGetFortune_OffensiveParam(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	mockService.On("GetFortune", mock.Anything, service.FortuneOptions{Offensive: service.OffensiveOnly}).
+		Return(&service.FortuneResponse{Fortune: "ok"}, nil)
+
+	req := httptest.NewRequest("GET", "/fortune?offensive=ONLY", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetFortune(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestGetFortune_PassesRequestContext(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "marker")
+	isRequestCtx := mock.MatchedBy(func(c context.Context) bool { return c.Value(ctxKey{}) == "marker" })
+
+	mockService.On("GetFortune", isRequestCtx, mock.AnythingOfType("service.FortuneOptions")).
+		Return(&service.FortuneResponse{Fortune: "ok"}, nil)
+
+	req := httptest.NewRequest("GET", "/fortune", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler.GetFortune(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestGetFortune_Match(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	expectedOpts := service.FortuneOptions{Match: "cats", IgnoreCase: true, Files: []string{"animals"}}
+	mockService.On("GetFortune", mock.Anything, expectedOpts).Return(&service.FortuneResponse{Fortune: "Cats rule."}, nil)
+
+	req := httptest.NewRequest("GET", "/fortune?match=cats&ignore_case=true&files=animals", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetFortune(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestGetFortune_Count(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	expected := &service.FortunesResponse{
+		Fortunes: []service.FortuneResponse{{Fortune: "One."}, {Fortune: "Two."}},
+		Count:    2,
+		Partial:  true,
+	}
+	mockService.On("GetFortunes", mock.Anything, service.FortuneOptions{Short: true}, 5).Return(expected, nil)
+
+	req := httptest.NewRequest("GET", "/fortune?count=5&short=true", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetFortune(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+	mockService.AssertNotCalled(t, "GetFortune", mock.Anything, mock.Anything)
+
+	var got service.FortunesResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+	assert.Equal(t, *expected, got)
+}
+
+func TestGetFortune_CountLimit(t *testing.T) {
+	mockService := new(MockFortuneService)
+	handler := NewHandler(mockService, zap.NewNop(), Limits{MaxCount: 50})
+
+	mockService.On("GetFortunes", mock.Anything, service.FortuneOptions{}, 50).
+		Return(&service.FortunesResponse{Fortunes: []service.FortuneResponse{{Fortune: "One."}}, Count: 1}, nil)
+
+	req := httptest.NewRequest("GET", "/fortune?count=50", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetFortune(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestGetFortune_Seed(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	mockService.On("GetFortune", mock.Anything, service.FortuneOptions{Seed: "bug-1234"}).
+		Return(&service.FortuneResponse{Fortune: "Again.", Seed: "bug-1234"}, nil)
+
+	req := httptest.NewRequest("GET", "/fortune?seed=bug-1234", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetFortune(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+
+	var got service.FortuneResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+	assert.Equal(t, "bug-1234", got.Seed)
+}
+
+func TestSearchFortunes_Seed(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	mockService.On("SearchFortunes", mock.Anything, "test", service.FortuneOptions{Pattern: "test", Seed: "bug-1234"}, defaultPage).
+		Return(&service.SearchResponse{Matches: []service.SearchMatch{}, Seed: "bug-1234"}, nil)
+
+	req := httptest.NewRequest("GET", "/fortune/search?pattern=test&seed=bug-1234", nil)
+	rr := httptest.NewRecorder()
+
+	handler.SearchFortunes(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+	assert.Contains(t, rr.Body.String(), `"seed":"bug-1234"`)
+}
+
+func TestGetDailyFortune(t *testing.T) {
+	handler, mockService := setupTestHandler()
+	handler.now = func() time.Time { return time.Date(2026, 10, 17, 2, 30, 0, 0, time.UTC) }
+
+	// 02:30 UTC is 22:30 on the 16th in New York, an hour and a half before
+	// local midnight.
+	opts := service.FortuneOptions{Short: true}
+	opts.Seed = service.DailySeed("2026-10-16", opts)
+	mockService.On("GetFortune", mock.Anything, opts).Return(&service.FortuneResponse{Fortune: "Today."}, nil)
+
+	req := httptest.NewRequest("GET", "/fortune/daily?short=true&tz=America/New_York", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetDailyFortune(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+	assert.Equal(t, "public, max-age=5400", rr.Header().Get("Cache-Control"))
+	assert.Equal(t, "Sat, 17 Oct 2026 04:00:00 GMT", rr.Header().Get("Expires"))
+}
+
+func TestGetDailyFortune_Periods(t *testing.T) {
+	testCases := []struct {
+		query          string
+		expectedLabel  string
+		expectedMaxAge string
+	}{
+		{query: "", expectedLabel: "2026-10-16", expectedMaxAge: "public, max-age=37800"},
+		{query: "?period=hour", expectedLabel: "2026-10-16T13", expectedMaxAge: "public, max-age=1800"},
+		{query: "?period=week", expectedLabel: "2026-W42", expectedMaxAge: "public, max-age=210600"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expectedLabel, func(t *testing.T) {
+			handler, mockService := setupTestHandler()
+			handler.now = func() time.Time { return time.Date(2026, 10, 16, 13, 30, 0, 0, time.UTC) }
+
+			opts := service.FortuneOptions{Seed: service.DailySeed(tc.expectedLabel, service.FortuneOptions{})}
+			mockService.On("GetFortune", mock.Anything, opts).Return(&service.FortuneResponse{Fortune: "Today."}, nil)
+
+			req := httptest.NewRequest("GET", "/fortune/daily"+tc.query, nil)
+			rr := httptest.NewRecorder()
+
+			handler.GetDailyFortune(rr, req)
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			mockService.AssertExpectations(t)
+			assert.Equal(t, tc.expectedMaxAge, rr.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestGetDailyFortune_RepeatedHour(t *testing.T) {
+	handler, mockService := setupTestHandler()
+	// 06:30 UTC is 01:30 EST, in the second 01:00 hour of the day daylight
+	// saving ends in New York.
+	handler.now = func() time.Time { return time.Date(2026, 11, 1, 6, 30, 0, 0, time.UTC) }
+
+	opts := service.FortuneOptions{Seed: service.DailySeed("2026-11-01T01", service.FortuneOptions{})}
+	mockService.On("GetFortune", mock.Anything, opts).Return(&service.FortuneResponse{Fortune: "Again."}, nil)
+
+	req := httptest.NewRequest("GET", "/fortune/daily?period=hour&tz=America/New_York", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetDailyFortune(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+	assert.Equal(t, "public, max-age=1800", rr.Header().Get("Cache-Control"))
+	assert.Equal(t, "Sun, 01 Nov 2026 07:00:00 GMT", rr.Header().Get("Expires"))
+}
+
+func TestGetDailyFortune_InvalidOptions(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	req := httptest.NewRequest("GET", "/fortune/daily?tz=Mars/Olympus&period=month&length=x", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetDailyFortune(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	mockService.AssertNotCalled(t, "GetFortune", mock.Anything, mock.Anything)
+
+	var problem Problem
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+	fields := make([]string, len(problem.Errors))
+	for i, fe := range problem.Errors {
+		fields[i] = fe.Field
+	}
+	assert.ElementsMatch(t, []string{"tz", "period", "length"}, fields)
+}
+
+func TestGetFortuneByID(t *testing.T) {
+	const id = "wisdom-0123456789abcdef"
+
+	t.Run("Found", func(t *testing.T) {
+		handler, mockService := setupTestHandler()
+		expected := &service.FortuneResponse{ID: id, Fortune: "Know thyself.", SourceFile: "wisdom"}
+		mockService.On("GetFortuneByID", mock.Anything, id).Return(expected, nil)
+
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/fortune/"+id, nil), map[string]string{"id": id})
+		rr := httptest.NewRecorder()
+
+		handler.GetFortuneByID(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockService.AssertExpectations(t)
+
+		var got service.FortuneResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.Equal(t, *expected, got)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		handler, mockService := setupTestHandler()
+		mockService.On("GetFortuneByID", mock.Anything, id).
+			Return(nil, fmt.Errorf("%w: %q", service.ErrFortuneNotFound, id))
+
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/fortune/"+id, nil), map[string]string{"id": id})
+		rr := httptest.NewRecorder()
+
+		handler.GetFortuneByID(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+	})
+}
+
+func TestGetSimilarFortunes(t *testing.T) {
+	const id = "wisdom-0123456789abcdef"
+
+	t.Run("Default count", func(t *testing.T) {
+		handler, mockService := setupTestHandler()
+		expected := &service.SimilarResponse{
+			ID: id,
+			Similar: []service.SimilarFortune{{
+				FortuneResponse: service.FortuneResponse{ID: "wisdom-fedcba9876543210", Fortune: "Know thy neighbour.", SourceFile: "wisdom"},
+				Index:           4,
+				Similarity:      0.4142,
+			}},
+			Count: 1,
+		}
+		mockService.On("SimilarFortunes", mock.Anything, id, service.FortuneOptions{}, DefaultSimilarCount).Return(expected, nil)
+
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/fortune/"+id+"/similar", nil), map[string]string{"id": id})
+		rr := httptest.NewRecorder()
+
+		handler.GetSimilarFortunes(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockService.AssertExpectations(t)
+
+		var got service.SimilarResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.Equal(t, *expected, got)
+	})
+
+	t.Run("Count and files", func(t *testing.T) {
+		handler, mockService := setupTestHandler()
+		opts := service.FortuneOptions{Files: []string{"wisdom", "literature"}}
+		mockService.On("SimilarFortunes", mock.Anything, id, opts, 3).
+			Return(&service.SimilarResponse{ID: id, Similar: []service.SimilarFortune{}}, nil)
+
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/fortune/"+id+"/similar?count=3&files=wisdom,literature", nil), map[string]string{"id": id})
+		rr := httptest.NewRecorder()
+
+		handler.GetSimilarFortunes(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("Count over maximum", func(t *testing.T) {
+		handler, mockService := setupTestHandler()
+
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/fortune/"+id+"/similar?count=11", nil), map[string]string{"id": id})
+		rr := httptest.NewRecorder()
+
+		handler.GetSimilarFortunes(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"field":"count"`)
+		mockService.AssertNotCalled(t, "SimilarFortunes", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		handler, mockService := setupTestHandler()
+		mockService.On("SimilarFortunes", mock.Anything, id, service.FortuneOptions{}, DefaultSimilarCount).
+			Return(nil, fmt.Errorf("%w: %q", service.ErrFortuneNotFound, id))
+
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/fortune/"+id+"/similar", nil), map[string]string{"id": id})
+		rr := httptest.NewRecorder()
+
+		handler.GetSimilarFortunes(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Contains(t, rr.Body.String(), problemFortuneNotFound)
+	})
 }
 
 func TestListFiles_Success(t *testing.T) {
 	handler, mockService := setupTestHandler()
 
-	expectedFiles := []string{"star-trek", "wisdom"}
-	mockService.On("ListFiles").Return(expectedFiles, nil)
+	expectedFiles := []service.FileInfo{{Name: "star-trek"}, {Name: "wisdom"}, {Name: "songs-poems", Offensive: true}}
+	mockService.On("ListFiles", mock.Anything, service.FileQuery{}).Return(expectedFiles, nil)
 
 	req := httptest.NewRequest("GET", "/fortune/files", nil)
 	rr := httptest.NewRecorder()
@@ -123,21 +685,147 @@ func TestListFiles_Success(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rr.Code)
 	mockService.AssertExpectations(t)
 
-	var response map[string]interface{}
+	var response struct {
+		Files []service.FileInfo `json:"files"`
+		Count int                `json:"count"`
+	}
 	err := json.Unmarshal(rr.Body.Bytes(), &response)
 	assert.NoError(t, err)
-	assert.Equal(t, float64(2), response["count"]) // JSON numbers are float64 by default
-	assert.ElementsMatch(t, expectedFiles, response["files"])
+	assert.Equal(t, 3, response.Count)
+	assert.ElementsMatch(t, expectedFiles, response.Files)
+}
+
+func TestListFiles_Query(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	expectedQuery := service.FileQuery{Prefix: "star", Sort: service.SortByCount, Descending: true}
+	mockService.On("ListFiles", mock.Anything, expectedQuery).Return([]service.FileInfo{}, nil)
+
+	req := httptest.NewRequest("GET", "/fortune/files?prefix=star&sort=count&order=desc", nil)
+	rr := httptest.NewRecorder()
+
+	handler.ListFiles(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestListFiles_InvalidQuery(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	req := httptest.NewRequest("GET", "/fortune/files?sort=colour&order=up", nil)
+	rr := httptest.NewRecorder()
+
+	handler.ListFiles(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	mockService.AssertNotCalled(t, "ListFiles", mock.Anything, mock.Anything)
+
+	var problem Problem
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+	fields := make([]string, len(problem.Errors))
+	for i, fe := range problem.Errors {
+		fields[i] = fe.Field
+	}
+	assert.ElementsMatch(t, []string{"sort", "order"}, fields)
+}
+
+func TestGetFile(t *testing.T) {
+	testCases := []struct {
+		name           string
+		file           *service.FileInfo
+		err            error
+		expectedStatus int
+	}{
+		{
+			name:           "Found",
+			file:           &service.FileInfo{Name: "wisdom", Count: 42},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Not found",
+			err:            fmt.Errorf("%w: %q", service.ErrFileNotFound, "wisdom"),
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, mockService := setupTestHandler()
+			if tc.file != nil {
+				mockService.On("GetFile", mock.Anything, "wisdom").Return(tc.file, nil)
+			} else {
+				mockService.On("GetFile", mock.Anything, "wisdom").Return(nil, tc.err)
+			}
+
+			req := mux.SetURLVars(httptest.NewRequest("GET", "/fortune/files/wisdom", nil), map[string]string{"name": "wisdom"})
+			rr := httptest.NewRecorder()
+
+			handler.GetFile(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			mockService.AssertExpectations(t)
+
+			if tc.file != nil {
+				var got service.FileInfo
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+				assert.Equal(t, *tc.file, got)
+			}
+		})
+	}
+}
+
+func TestGetProbabilities(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	expectedOpts := service.FortuneOptions{Equal: true, Files: []string{"wisdom", "zippy"}, Percentages: []string{"30"}}
+	expected := []service.FileProbability{
+		{Name: "wisdom", Fortunes: 10, Percentage: 30},
+		{Name: "zippy", Fortunes: 5, Percentage: 70},
+	}
+	mockService.On("Probabilities", mock.Anything, expectedOpts).Return(expected, nil)
+
+	req := httptest.NewRequest("GET", "/fortune/probabilities?equal=true&files=wisdom,zippy&percentages=30", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetProbabilities(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+
+	var response struct {
+		Files []service.FileProbability `json:"files"`
+		Count int                       `json:"count"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.Count)
+	assert.Equal(t, expected, response.Files)
+}
+
+func TestGetProbabilities_InvalidOptions(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	req := httptest.NewRequest("GET", "/fortune/probabilities?files=wisdom&percentages=120", nil)
+	rr := httptest.NewRecorder()
+
+	handler.GetProbabilities(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	mockService.AssertNotCalled(t, "Probabilities", mock.Anything, mock.Anything)
 }
 
 func TestSearchFortunes_Success(t *testing.T) {
 	handler, mockService := setupTestHandler()
 
 	expectedSearch := &service.SearchResponse{
-		Matches: []service.FortuneResponse{{Fortune: "A search has found you."}},
-		Count:   1,
+		Matches: []service.SearchMatch{{
+			FortuneResponse: service.FortuneResponse{ID: "wisdom-0123456789abcdef", Fortune: "A search has found you.", SourceFile: "wisdom"},
+			Index:           3,
+			Highlights:      []service.Span{{Start: 2, End: 8}},
+		}},
+		Count: 1,
 	}
-	mockService.On("SearchFortunes", "test", mock.AnythingOfType("service.FortuneOptions")).Return(expectedSearch, nil)
+	mockService.On("SearchFortunes", mock.Anything, "test", mock.AnythingOfType("service.FortuneOptions"), defaultPage).Return(expectedSearch, nil)
 
 	req := httptest.NewRequest("GET", "/fortune/search?pattern=test", nil)
 	rr := httptest.NewRecorder()
@@ -153,6 +841,213 @@ func TestSearchFortunes_Success(t *testing.T) {
 	assert.Equal(t, *expectedSearch, actualSearch)
 }
 
+func TestSearchFortunes_Query(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	expected := &service.SearchResponse{Matches: []service.SearchMatch{}}
+	mockService.On("QueryFortunes", mock.Anything, `cat "nine lives"`, service.FortuneOptions{Short: true}, defaultPage).Return(expected, nil)
+
+	req := httptest.NewRequest("GET", "/fortune/search?q=cat+%22nine+lives%22&short=true", nil)
+	rr := httptest.NewRecorder()
+
+	handler.SearchFortunes(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+	mockService.AssertNotCalled(t, "SearchFortunes", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSearchFortunes_QueryWithPattern(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	req := httptest.NewRequest("GET", "/fortune/search?q=cat&pattern=dog", nil)
+	rr := httptest.NewRecorder()
+
+	handler.SearchFortunes(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	mockService.AssertNotCalled(t, "QueryFortunes", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSearchFortunes_Fuzzy(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	expected := &service.SearchResponse{Matches: []service.SearchMatch{{
+		FortuneResponse: service.FortuneResponse{Fortune: "E = mc^2\n\t\t-- Albert Einstein", SourceFile: "science"},
+		Highlights:      []service.Span{{Start: 22, End: 30}},
+		Fuzzy:           []service.FuzzyMatch{{Term: "einstien", Matched: "einstein", Distance: 1}},
+	}}, Count: 1}
+	mockService.On("QueryFortunes", mock.Anything, "einstien", service.FortuneOptions{Fuzzy: true, FuzzyDistance: 1}, defaultPage).Return(expected, nil)
+
+	req := httptest.NewRequest("GET", "/fortune/search?q=einstien&fuzzy=true&distance=1", nil)
+	rr := httptest.NewRecorder()
+
+	handler.SearchFortunes(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"fuzzy":[{"term":"einstien","matched":"einstein","distance":1}]`)
+	mockService.AssertExpectations(t)
+}
+
+func TestSearchFortunes_FuzzyInvalid(t *testing.T) {
+	testCases := []struct {
+		name  string
+		query string
+		field string
+		code  string
+	}{
+		{name: "With pattern", query: "pattern=einst&fuzzy=true", field: "fuzzy", code: service.CodeConflict},
+		{name: "Distance without fuzzy", query: "q=einstien&distance=1", field: "distance", code: service.CodeConflict},
+		{name: "Distance not a number", query: "q=einstien&fuzzy&distance=far", field: "distance", code: service.CodeInvalidInteger},
+		{name: "Distance zero", query: "q=einstien&fuzzy&distance=0", field: "distance", code: service.CodeOutOfRange},
+		{name: "Distance too large", query: "q=einstien&fuzzy&distance=5", field: "distance", code: service.CodeOutOfRange},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, mockService := setupTestHandler()
+
+			req := httptest.NewRequest("GET", "/fortune/search?"+tc.query, nil)
+			rr := httptest.NewRecorder()
+
+			handler.SearchFortunes(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			var problem Problem
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+			if assert.Len(t, problem.Errors, 1) {
+				assert.Equal(t, tc.field, problem.Errors[0].Field)
+				assert.Equal(t, tc.code, problem.Errors[0].Code)
+			}
+			mockService.AssertNotCalled(t, "QueryFortunes", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			mockService.AssertNotCalled(t, "SearchFortunes", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestSearchFortunes_Page(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	expected := &service.SearchResponse{Matches: []service.SearchMatch{}, Total: 120, NextCursor: "next"}
+	page := service.SearchPage{Sort: service.SearchByLength, Limit: 50, Offset: 20}
+	mockService.On("QueryFortunes", mock.Anything, "cat", service.FortuneOptions{}, page).Return(expected, nil)
+
+	req := httptest.NewRequest("GET", "/fortune/search?q=cat&sort=Length&limit=50&offset=20", nil)
+	rr := httptest.NewRecorder()
+
+	handler.SearchFortunes(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"total":120`)
+	assert.Contains(t, rr.Body.String(), `"next_cursor":"next"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestSearchFortunes_Cursor(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	page := service.SearchPage{Limit: DefaultSearchLimit, Cursor: "abc"}
+	mockService.On("SearchFortunes", mock.Anything, "cat", service.FortuneOptions{Pattern: "cat"}, page).
+		Return(&service.SearchResponse{Matches: []service.SearchMatch{}}, nil)
+
+	req := httptest.NewRequest("GET", "/fortune/search?pattern=cat&cursor=abc", nil)
+	rr := httptest.NewRecorder()
+
+	handler.SearchFortunes(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestSearchFortunes_PageInvalid(t *testing.T) {
+	testCases := []struct {
+		name  string
+		query string
+		field string
+		code  string
+	}{
+		{name: "Limit not a number", query: "limit=many", field: "limit", code: service.CodeInvalidInteger},
+		{name: "Limit zero", query: "limit=0", field: "limit", code: service.CodeOutOfRange},
+		{name: "Limit over maximum", query: "limit=101", field: "limit", code: service.CodeOutOfRange},
+		{name: "Negative offset", query: "offset=-1", field: "offset", code: service.CodeOutOfRange},
+		{name: "Offset not a number", query: "offset=x", field: "offset", code: service.CodeInvalidInteger},
+		{name: "Cursor and offset", query: "offset=5&cursor=abc", field: "cursor", code: service.CodeConflict},
+		{name: "Unknown sort", query: "sort=random", field: "sort", code: service.CodeInvalidValue},
+		{name: "Unknown facet", query: "facets=file,author", field: "facets", code: service.CodeInvalidValue},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, mockService := setupTestHandler()
+
+			req := httptest.NewRequest("GET", "/fortune/search?q=cat&"+tc.query, nil)
+			rr := httptest.NewRecorder()
+
+			handler.SearchFortunes(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			var problem Problem
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+			if assert.Len(t, problem.Errors, 1) {
+				assert.Equal(t, tc.field, problem.Errors[0].Field)
+				assert.Equal(t, tc.code, problem.Errors[0].Code)
+			}
+			mockService.AssertNotCalled(t, "QueryFortunes", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestSearchFortunes_Facets(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	expected := &service.SearchResponse{
+		Matches: []service.SearchMatch{},
+		Total:   3,
+		Facets: &service.SearchFacets{
+			File:   map[string]int{"animals": 2, "science": 1},
+			Length: map[string]int{service.LengthShort: 3, service.LengthMedium: 0, service.LengthLong: 0},
+		},
+	}
+	page := service.SearchPage{Limit: DefaultSearchLimit, Facets: []string{service.FacetFile, service.FacetLength}}
+	mockService.On("QueryFortunes", mock.Anything, "cat", service.FortuneOptions{}, page).Return(expected, nil)
+
+	req := httptest.NewRequest("GET", "/fortune/search?q=cat&facets=file,+Length", nil)
+	rr := httptest.NewRecorder()
+
+	handler.SearchFortunes(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+
+	var actual service.SearchResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &actual))
+	assert.Equal(t, expected.Facets, actual.Facets)
+}
+
+func TestSearchFortunes_MaxSearchLimit(t *testing.T) {
+	mockService := new(MockFortuneService)
+	handler := NewHandler(mockService, zap.NewNop(), Limits{MaxSearchLimit: 5})
+
+	page := service.SearchPage{Limit: 5}
+	mockService.On("QueryFortunes", mock.Anything, "cat", service.FortuneOptions{}, page).
+		Return(&service.SearchResponse{Matches: []service.SearchMatch{}}, nil)
+
+	req := httptest.NewRequest("GET", "/fortune/search?q=cat", nil)
+	rr := httptest.NewRecorder()
+
+	handler.SearchFortunes(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "the default page shrinks to the maximum")
+	mockService.AssertExpectations(t)
+
+	req = httptest.NewRequest("GET", "/fortune/search?q=cat&limit=6", nil)
+	rr = httptest.NewRecorder()
+
+	handler.SearchFortunes(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
 func TestSearchFortunes_MissingPattern(t *testing.T) {
 	handler, _ := setupTestHandler()
 
@@ -163,8 +1058,8 @@ func TestSearchFortunes_MissingPattern(t *testing.T) {
 
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
 
-	var errResponse ErrorResponse
+	var errResponse Problem
 	err := json.Unmarshal(rr.Body.Bytes(), &errResponse)
 	assert.NoError(t, err)
-	assert.Equal(t, "Missing required parameter", errResponse.Error)
+	assert.Equal(t, "Missing required parameter", errResponse.Title)
 }
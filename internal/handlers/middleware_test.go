@@ -70,6 +70,34 @@ func TestLoggingMiddleware(t *testing.T) {
 	assert.NotZero(t, fields["duration"])
 }
 
+func TestLoggingMiddleware_Flush(t *testing.T) {
+	handler := LoggingMiddleware(zap.NewNop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, http.NewResponseController(w).Flush())
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.True(t, rr.Flushed, "streamed responses reach the client through the wrapper")
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	var deadline time.Time
+	var hasDeadline bool
+	inspect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, hasDeadline = r.Context().Deadline()
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	TimeoutMiddleware(time.Minute)(inspect).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.True(t, hasDeadline)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+
+	// A zero timeout leaves the request context untouched.
+	TimeoutMiddleware(0)(inspect).ServeHTTP(httptest.NewRecorder(), req)
+	assert.False(t, hasDeadline)
+}
+
 func TestPerClientRateLimit(t *testing.T) {
 	// Reset the clients map for a clean test run.
 	clients = make(map[string]*client)
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fortune-api/internal/service"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// Problem types reported in the "type" member of error responses. They are
+// relative URIs, resolved against the API's own address.
+const (
+	problemInternal           = "/problems/internal"
+	problemMissingParameter   = "/problems/missing-parameter"
+	problemInvalidParameter   = "/problems/invalid-parameter"
+	problemFileNotFound       = "/problems/file-not-found"
+	problemFortuneNotFound    = "/problems/fortune-not-found"
+	problemNoMatch            = "/problems/no-match"
+	problemBadPattern         = "/problems/bad-pattern"
+	problemInvalidQuery       = "/problems/invalid-query"
+	problemOffensiveDisabled  = "/problems/offensive-disabled"
+	problemBackendTimeout     = "/problems/backend-timeout"
+	problemBackendUnavailable = "/problems/backend-unavailable"
+)
+
+// Problem is an RFC 7807 problem details object, served as
+// application/problem+json.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Errors lists each invalid field for invalid-parameter problems.
+	Errors []service.FieldError `json:"errors,omitempty"`
+
+	// Position is the character offset of the mistake in invalid-query
+	// problems, counting from zero.
+	Position *int `json:"position,omitempty"`
+}
+
+// writeServiceError maps a service error onto a problem response. Error text
+// from the backends is only echoed for the typed errors, whose messages are
+// built from the request itself; anything unexpected is logged and reported
+// without detail.
+func (h *Handler) writeServiceError(w http.ResponseWriter, r *http.Request, err error, title string) {
+	if problem, ok := h.problemFor(r, err, title); ok {
+		h.writeJSON(w, problem.Status, "application/problem+json", problem)
+	}
+}
+
+// problemFor builds the problem writeServiceError reports for err. It
+// returns false when the client has gone away and there is nobody left to
+// answer.
+func (h *Handler) problemFor(r *http.Request, err error, title string) (Problem, bool) {
+	var (
+		verr *service.ValidationError
+		qerr *service.QuerySyntaxError
+	)
+	switch {
+	case errors.As(err, &verr):
+		return Problem{
+			Type:     problemInvalidParameter,
+			Title:    "Invalid parameter",
+			Status:   http.StatusBadRequest,
+			Detail:   verr.Error(),
+			Instance: r.URL.RequestURI(),
+			Errors:   verr.Errors,
+		}, true
+	case errors.As(err, &qerr):
+		return Problem{
+			Type:     problemInvalidQuery,
+			Title:    "Invalid search query",
+			Status:   http.StatusBadRequest,
+			Detail:   qerr.Error(),
+			Instance: r.URL.RequestURI(),
+			Position: &qerr.Position,
+		}, true
+	case errors.Is(err, service.ErrFileNotFound):
+		return newProblem(r, http.StatusNotFound, problemFileNotFound, "Fortune file not found", err.Error()), true
+	case errors.Is(err, service.ErrFortuneNotFound):
+		return newProblem(r, http.StatusNotFound, problemFortuneNotFound, "Fortune not found", err.Error()), true
+	case errors.Is(err, service.ErrNoMatch):
+		return newProblem(r, http.StatusNotFound, problemNoMatch, "No matching fortune", "no fortune matched the requested options"), true
+	case errors.Is(err, service.ErrBadPattern):
+		return newProblem(r, http.StatusBadRequest, problemBadPattern, "Invalid search pattern", err.Error()), true
+	case errors.Is(err, service.ErrOffensiveDisabled):
+		return newProblem(r, http.StatusForbidden, problemOffensiveDisabled, "Offensive fortunes disabled", "this server does not serve offensive fortunes"), true
+	case errors.Is(err, service.ErrBackendTimeout), errors.Is(err, context.DeadlineExceeded):
+		h.logger.Warn(title, zap.Error(err))
+		return newProblem(r, http.StatusGatewayTimeout, problemBackendTimeout, "Request timed out", "the fortune backend did not respond in time"), true
+	case errors.Is(err, context.Canceled) && r.Context().Err() != nil:
+		h.logger.Info(title, zap.Error(err))
+		return Problem{}, false
+	case errors.Is(err, service.ErrBackendUnavailable):
+		h.logger.Error(title, zap.Error(err))
+		return newProblem(r, http.StatusServiceUnavailable, problemBackendUnavailable, "Fortune backend unavailable", "fortunes cannot be served right now"), true
+	default:
+		h.logger.Error(title, zap.Error(err))
+		return newProblem(r, http.StatusInternalServerError, problemInternal, title, ""), true
+	}
+}
+
+func (h *Handler) writeProblem(w http.ResponseWriter, r *http.Request, status int, problemType, title, detail string) {
+	h.writeJSON(w, status, "application/problem+json", newProblem(r, status, problemType, title, detail))
+}
+
+func newProblem(r *http.Request, status int, problemType, title, detail string) Problem {
+	return Problem{
+		Type:     problemType,
+		Title:    title,
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.RequestURI(),
+	}
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, statusCode int, contentType string, data any) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		h.logger.Error("Failed to encode JSON response", zap.Error(err))
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fortune-api/internal/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWriteServiceError(t *testing.T) {
+	testCases := []struct {
+		name         string
+		err          error
+		expectedCode int
+		expectedType string
+	}{
+		{
+			name:         "Validation",
+			err:          &service.ValidationError{Errors: []service.FieldError{{Field: "length", Value: "x", Code: service.CodeInvalidInteger}}},
+			expectedCode: http.StatusBadRequest,
+			expectedType: problemInvalidParameter,
+		},
+		{
+			name:         "File not found",
+			err:          fmt.Errorf("%w: %q", service.ErrFileNotFound, "nope"),
+			expectedCode: http.StatusNotFound,
+			expectedType: problemFileNotFound,
+		},
+		{
+			name:         "Fortune not found",
+			err:          fmt.Errorf("%w: %q", service.ErrFortuneNotFound, "wisdom-0123456789abcdef"),
+			expectedCode: http.StatusNotFound,
+			expectedType: problemFortuneNotFound,
+		},
+		{
+			name:         "No match",
+			err:          service.ErrNoMatch,
+			expectedCode: http.StatusNotFound,
+			expectedType: problemNoMatch,
+		},
+		{
+			name:         "Bad pattern",
+			err:          fmt.Errorf("%w: missing closing )", service.ErrBadPattern),
+			expectedCode: http.StatusBadRequest,
+			expectedType: problemBadPattern,
+		},
+		{
+			name:         "Invalid query",
+			err:          &service.QuerySyntaxError{Query: "(cat", Position: 0, Message: "unclosed parenthesis"},
+			expectedCode: http.StatusBadRequest,
+			expectedType: problemInvalidQuery,
+		},
+		{
+			name:         "Offensive disabled",
+			err:          service.ErrOffensiveDisabled,
+			expectedCode: http.StatusForbidden,
+			expectedType: problemOffensiveDisabled,
+		},
+		{
+			name:         "Backend timeout",
+			err:          fmt.Errorf("%w: %w", service.ErrBackendTimeout, context.DeadlineExceeded),
+			expectedCode: http.StatusGatewayTimeout,
+			expectedType: problemBackendTimeout,
+		},
+		{
+			name:         "Bare deadline",
+			err:          context.DeadlineExceeded,
+			expectedCode: http.StatusGatewayTimeout,
+			expectedType: problemBackendTimeout,
+		},
+		{
+			name:         "Backend unavailable",
+			err:          fmt.Errorf("%w: exec: \"fortune\": executable file not found", service.ErrBackendUnavailable),
+			expectedCode: http.StatusServiceUnavailable,
+			expectedType: problemBackendUnavailable,
+		},
+		{
+			name:         "Unknown",
+			err:          errors.New("fork/exec /usr/games/fortune: exit status 2"),
+			expectedCode: http.StatusInternalServerError,
+			expectedType: problemInternal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, mockService := setupTestHandler()
+			mockService.On("GetFortune", mock.Anything, mock.AnythingOfType("service.FortuneOptions")).Return(nil, tc.err)
+
+			req := httptest.NewRequest("GET", "/fortune?short=true", nil)
+			rr := httptest.NewRecorder()
+
+			handler.GetFortune(rr, req)
+
+			assert.Equal(t, tc.expectedCode, rr.Code)
+			assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+
+			var problem Problem
+			err := json.Unmarshal(rr.Body.Bytes(), &problem)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedType, problem.Type)
+			assert.Equal(t, tc.expectedCode, problem.Status)
+			assert.NotEmpty(t, problem.Title)
+			assert.Equal(t, "/fortune?short=true", problem.Instance)
+			assert.NotContains(t, problem.Detail, "exec", "backend error text must not leak")
+		})
+	}
+}
+
+func TestWriteServiceError_QueryPosition(t *testing.T) {
+	handler, mockService := setupTestHandler()
+	mockService.On("QueryFortunes", mock.Anything, "cat )", mock.AnythingOfType("service.FortuneOptions"), mock.Anything).
+		Return(nil, &service.QuerySyntaxError{Query: "cat )", Position: 4, Message: "unexpected )"})
+
+	req := httptest.NewRequest("GET", "/fortune/search?q=cat+)", nil)
+	rr := httptest.NewRecorder()
+
+	handler.SearchFortunes(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	var body map[string]any
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, problemInvalidQuery, body["type"])
+	assert.Equal(t, float64(4), body["position"])
+	assert.Contains(t, body["detail"], "position 4")
+}
+
+func TestWriteServiceError_ClientGone(t *testing.T) {
+	handler, mockService := setupTestHandler()
+	mockService.On("GetFortune", mock.Anything, mock.AnythingOfType("service.FortuneOptions")).Return(nil, context.Canceled)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/fortune", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler.GetFortune(rr, req)
+
+	assert.Empty(t, rr.Body.String(), "nothing should be written for a departed client")
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fortune-api/internal/service"
+	"mime"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// contentTypeNDJSON is newline-delimited JSON: one JSON value per line.
+const contentTypeNDJSON = "application/x-ndjson"
+
+// acceptsNDJSON reports whether the Accept header asks for newline-delimited
+// JSON.
+func acceptsNDJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err == nil && mediaType == contentTypeNDJSON && params["q"] != "0" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ndjsonStream writes one JSON value per line and flushes every line out to
+// the client as soon as it is written. The status and headers go out with
+// the first line.
+type ndjsonStream struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	enc     *json.Encoder
+	started bool
+	// err is the first write that failed; nothing more is written after it.
+	err error
+}
+
+func newNDJSONStream(w http.ResponseWriter) *ndjsonStream {
+	return &ndjsonStream{w: w, rc: http.NewResponseController(w), enc: json.NewEncoder(w)}
+}
+
+// start sends the status and headers if they haven't gone out yet.
+func (s *ndjsonStream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.w.Header().Set("Content-Type", contentTypeNDJSON)
+	s.w.Header().Set("X-Content-Type-Options", "nosniff")
+	s.w.WriteHeader(http.StatusOK)
+}
+
+func (s *ndjsonStream) write(v any) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.start()
+	if err := s.enc.Encode(v); err != nil {
+		s.err = err
+		return err
+	}
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.err = err
+		return err
+	}
+	return nil
+}
+
+// streamSearch writes the matches of a search as newline-delimited JSON, a
+// match per line, as the service finds them. A failure before the first
+// match gets the usual problem response; after it, the problem is written
+// as the last line instead, so a stream that ends on a line with a "type"
+// is incomplete. The search stops as soon as the client goes away.
+func (h *Handler) streamSearch(w http.ResponseWriter, r *http.Request, query, pattern string, opts service.FortuneOptions, page service.SearchPage) {
+	stream := newNDJSONStream(w)
+	write := func(m service.SearchMatch) error { return stream.write(m) }
+
+	err := h.eachMatch(r, query, pattern, opts, page, write)
+	switch {
+	case err == nil:
+		stream.start()
+	case !stream.started:
+		h.writeServiceError(w, r, err, "Search failed")
+	case stream.err != nil:
+		h.logger.Info("Search stream closed by client", zap.Error(stream.err))
+	default:
+		if problem, ok := h.problemFor(r, err, "Search failed"); ok {
+			stream.write(problem)
+		}
+	}
+}
+
+// eachMatch calls fn with the matches of a search, streaming them if the
+// service can and paging through them otherwise.
+func (h *Handler) eachMatch(r *http.Request, query, pattern string, opts service.FortuneOptions, page service.SearchPage, fn service.MatchFunc) error {
+	if streamer, ok := h.fortuneService.(service.SearchStreamer); ok {
+		if query != "" {
+			return streamer.StreamQuery(r.Context(), query, opts, page, fn)
+		}
+		return streamer.StreamSearch(r.Context(), pattern, opts, page, fn)
+	}
+
+	var (
+		results *service.SearchResponse
+		err     error
+	)
+	if query != "" {
+		results, err = h.fortuneService.QueryFortunes(r.Context(), query, opts, page)
+	} else {
+		results, err = h.fortuneService.SearchFortunes(r.Context(), pattern, opts, page)
+	}
+	if err != nil {
+		return err
+	}
+	for _, m := range results.Matches {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
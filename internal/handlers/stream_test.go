@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fortune-api/internal/service"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+// streamingMockService is a MockFortuneService that also streams searches,
+// handing fn the given matches and then returning err.
+type streamingMockService struct {
+	*MockFortuneService
+	matches []service.SearchMatch
+	err     error
+
+	// page and sent record the last streamed search.
+	page service.SearchPage
+	sent int
+}
+
+func (m *streamingMockService) StreamSearch(ctx context.Context, pattern string, opts service.FortuneOptions, page service.SearchPage, fn service.MatchFunc) error {
+	return m.stream(page, fn)
+}
+
+func (m *streamingMockService) StreamQuery(ctx context.Context, query string, opts service.FortuneOptions, page service.SearchPage, fn service.MatchFunc) error {
+	return m.stream(page, fn)
+}
+
+func (m *streamingMockService) stream(page service.SearchPage, fn service.MatchFunc) error {
+	m.page = page
+	for _, match := range m.matches {
+		m.sent++
+		if err := fn(match); err != nil {
+			return err
+		}
+	}
+	return m.err
+}
+
+func testMatches(indexes ...int) []service.SearchMatch {
+	matches := make([]service.SearchMatch, len(indexes))
+	for i, index := range indexes {
+		matches[i] = service.SearchMatch{
+			FortuneResponse: service.FortuneResponse{Fortune: "A streamed fortune.", SourceFile: "wisdom"},
+			Index:           index,
+			Highlights:      []service.Span{},
+		}
+	}
+	return matches
+}
+
+// ndjsonLines splits a streamed body into its lines.
+func ndjsonLines(t *testing.T, body string) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var v map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(line), &v), line)
+		lines = append(lines, v)
+	}
+	return lines
+}
+
+func newStreamRequest(target string) *http.Request {
+	req := httptest.NewRequest("GET", target, nil)
+	req.Header.Set("Accept", contentTypeNDJSON)
+	return req
+}
+
+func TestAcceptsNDJSON(t *testing.T) {
+	testCases := []struct {
+		accept   string
+		expected bool
+	}{
+		{accept: "", expected: false},
+		{accept: "application/json", expected: false},
+		{accept: "application/x-ndjson", expected: true},
+		{accept: "application/json, Application/X-NDJSON; charset=utf-8", expected: true},
+		{accept: "application/x-ndjson;q=0", expected: false},
+		{accept: "*/*", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.accept, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/fortune/search", nil)
+			req.Header.Set("Accept", tc.accept)
+			assert.Equal(t, tc.expected, acceptsNDJSON(req))
+		})
+	}
+}
+
+func TestSearchFortunes_Stream(t *testing.T) {
+	mockService := &streamingMockService{MockFortuneService: new(MockFortuneService), matches: testMatches(0, 4, 7)}
+	handler := NewHandler(mockService, zap.NewNop(), Limits{})
+
+	rr := httptest.NewRecorder()
+	handler.SearchFortunes(rr, newStreamRequest("/fortune/search?q=cat"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, contentTypeNDJSON, rr.Header().Get("Content-Type"))
+	assert.True(t, rr.Flushed)
+	assert.Equal(t, service.SearchPage{}, mockService.page, "a stream has no page limit unless one is given")
+
+	lines := ndjsonLines(t, rr.Body.String())
+	if assert.Len(t, lines, 3) {
+		assert.Equal(t, float64(4), lines[1]["index"])
+		assert.Equal(t, "wisdom", lines[1]["source_file"])
+	}
+
+	rr = httptest.NewRecorder()
+	handler.SearchFortunes(rr, newStreamRequest("/fortune/search?pattern=cat&limit=2&sort=length"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, service.SearchPage{Sort: service.SearchByLength, Limit: 2}, mockService.page)
+}
+
+func TestSearchFortunes_StreamEmpty(t *testing.T) {
+	mockService := &streamingMockService{MockFortuneService: new(MockFortuneService)}
+	handler := NewHandler(mockService, zap.NewNop(), Limits{})
+
+	rr := httptest.NewRecorder()
+	handler.SearchFortunes(rr, newStreamRequest("/fortune/search?q=cat"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, contentTypeNDJSON, rr.Header().Get("Content-Type"))
+	assert.Empty(t, rr.Body.String())
+}
+
+func TestSearchFortunes_StreamErrors(t *testing.T) {
+	t.Run("Before the first match", func(t *testing.T) {
+		mockService := &streamingMockService{
+			MockFortuneService: new(MockFortuneService),
+			err:                &service.QuerySyntaxError{Query: "cat AND", Position: 7, Message: "expected a term"},
+		}
+		handler := NewHandler(mockService, zap.NewNop(), Limits{})
+
+		rr := httptest.NewRecorder()
+		handler.SearchFortunes(rr, newStreamRequest("/fortune/search?q=cat+AND"))
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Body.String(), problemInvalidQuery)
+	})
+
+	t.Run("After the first match", func(t *testing.T) {
+		mockService := &streamingMockService{
+			MockFortuneService: new(MockFortuneService),
+			matches:            testMatches(0),
+			err:                service.ErrBackendTimeout,
+		}
+		handler := NewHandler(mockService, zap.NewNop(), Limits{})
+
+		rr := httptest.NewRecorder()
+		handler.SearchFortunes(rr, newStreamRequest("/fortune/search?q=cat"))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		lines := ndjsonLines(t, rr.Body.String())
+		if assert.Len(t, lines, 2) {
+			assert.Equal(t, problemBackendTimeout, lines[1]["type"], "the problem ends the stream")
+		}
+	})
+
+	t.Run("Invalid parameters", func(t *testing.T) {
+		mockService := &streamingMockService{MockFortuneService: new(MockFortuneService)}
+		handler := NewHandler(mockService, zap.NewNop(), Limits{})
+
+		rr := httptest.NewRecorder()
+		handler.SearchFortunes(rr, newStreamRequest("/fortune/search?q=cat&limit=1000"))
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Zero(t, mockService.sent)
+	})
+
+	t.Run("Facets", func(t *testing.T) {
+		mockService := &streamingMockService{MockFortuneService: new(MockFortuneService)}
+		handler := NewHandler(mockService, zap.NewNop(), Limits{})
+
+		rr := httptest.NewRecorder()
+		handler.SearchFortunes(rr, newStreamRequest("/fortune/search?q=cat&facets=file"))
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"field":"facets"`)
+	})
+}
+
+// failingWriter is a client that hangs up after the first write.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	w.writes++
+	if w.writes > 1 {
+		return 0, errors.New("connection reset by peer")
+	}
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestSearchFortunes_StreamClientGone(t *testing.T) {
+	mockService := &streamingMockService{MockFortuneService: new(MockFortuneService), matches: testMatches(0, 1, 2, 3)}
+	handler := NewHandler(mockService, zap.NewNop(), Limits{})
+
+	w := &failingWriter{ResponseRecorder: httptest.NewRecorder()}
+	handler.SearchFortunes(w, newStreamRequest("/fortune/search?q=cat"))
+
+	assert.Equal(t, 2, mockService.sent, "the search stops at the first failed write")
+	assert.Len(t, ndjsonLines(t, w.Body.String()), 1)
+}
+
+func TestSearchFortunes_StreamWithoutStreamer(t *testing.T) {
+	handler, mockService := setupTestHandler()
+
+	page := service.SearchPage{Limit: 5}
+	mockService.On("QueryFortunes", mock.Anything, "cat", service.FortuneOptions{}, page).
+		Return(&service.SearchResponse{Matches: testMatches(2, 3), Count: 2, Total: 2}, nil)
+
+	rr := httptest.NewRecorder()
+	handler.SearchFortunes(rr, newStreamRequest("/fortune/search?q=cat&limit=5"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, contentTypeNDJSON, rr.Header().Get("Content-Type"))
+	assert.Len(t, ndjsonLines(t, rr.Body.String()), 2)
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"fortune-api/internal/fortunefile"
+)
+
+// Directory is a configured fortune directory. OffensiveDir is where its
+// offensive databases live, relative to Path unless absolute; leave it empty
+// if the directory has none.
+type Directory struct {
+	Path         string
+	OffensiveDir string
+}
+
+// offensivePath returns the absolute location of the offensive databases.
+func (d Directory) offensivePath() string {
+	if d.OffensiveDir == "" || filepath.IsAbs(d.OffensiveDir) {
+		return d.OffensiveDir
+	}
+	return filepath.Join(d.Path, d.OffensiveDir)
+}
+
+// Catalog locates fortune databases across an ordered list of directories.
+// When two directories hold a database with the same name, the one listed
+// first wins. In safe mode the offensive databases are hidden from every
+// lookup, whatever the request asks for, and the fortunes on the denylist
+// are never served.
+type Catalog struct {
+	dirs     []Directory
+	safeMode bool
+	denylist *Denylist
+}
+
+// database is a single fortune file known to the catalog.
+type database struct {
+	Name      string
+	Path      string
+	Dir       string
+	Offensive bool
+}
+
+// NewCatalog creates a catalog over dirs. denylist may be nil.
+func NewCatalog(dirs []Directory, safeMode bool, denylist *Denylist) *Catalog {
+	return &Catalog{dirs: dirs, safeMode: safeMode, denylist: denylist}
+}
+
+// Paths returns the configured directories in order of precedence.
+func (c *Catalog) Paths() []string {
+	paths := make([]string, len(c.dirs))
+	for i, d := range c.dirs {
+		paths[i] = d.Path
+	}
+	return paths
+}
+
+// offensiveMode works out which databases a request may draw from. All is
+// fortune's -a and means include; an explicit Offensive setting takes
+// precedence. Asking for offensive content in safe mode is an error, except
+// through All, which then quietly covers the regular databases only.
+func (c *Catalog) offensiveMode(opts FortuneOptions) (OffensiveMode, error) {
+	mode := opts.Offensive
+	if mode == "" {
+		mode = OffensiveExclude
+		if opts.All {
+			mode = OffensiveInclude
+		}
+	}
+
+	if c != nil && c.safeMode && mode != OffensiveExclude {
+		if opts.Offensive != "" && opts.Offensive != OffensiveExclude {
+			return "", ErrOffensiveDisabled
+		}
+		mode = OffensiveExclude
+	}
+	return mode, nil
+}
+
+// Databases returns the databases that have a strfile index, sorted by name
+// with regular databases ahead of offensive ones of the same name. mode
+// selects the regular databases, the offensive ones, or both. Directories
+// that don't exist are skipped, but at least one must be readable.
+func (c *Catalog) Databases(mode OffensiveMode) ([]database, error) {
+	type key struct {
+		name      string
+		offensive bool
+	}
+	var (
+		dbs   []database
+		seen  = make(map[key]bool)
+		found bool
+	)
+
+	add := func(dir Directory, path string, offensive bool) error {
+		entries, err := scanDir(path, offensive)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		for _, db := range entries {
+			k := key{db.Name, db.Offensive}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			db.Dir = dir.Path
+			dbs = append(dbs, db)
+		}
+		return nil
+	}
+
+	for _, dir := range c.dirs {
+		if err := add(dir, dir.Path, false); err != nil {
+			return nil, err
+		}
+		if mode == OffensiveExclude || c.safeMode || dir.OffensiveDir == "" {
+			continue
+		}
+		if err := add(dir, dir.offensivePath(), true); err != nil {
+			return nil, err
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no readable fortune directory in %v: %w", c.Paths(), fs.ErrNotExist)
+	}
+
+	if mode == OffensiveOnly {
+		filtered := dbs[:0]
+		for _, db := range dbs {
+			if db.Offensive {
+				filtered = append(filtered, db)
+			}
+		}
+		dbs = filtered
+	}
+
+	sort.SliceStable(dbs, func(i, j int) bool {
+		if dbs[i].Name != dbs[j].Name {
+			return dbs[i].Name < dbs[j].Name
+		}
+		return !dbs[i].Offensive && dbs[j].Offensive
+	})
+	return dbs, nil
+}
+
+// Lookup finds the database with the given name. When mode includes both
+// kinds and the name exists in each, the regular database wins.
+func (c *Catalog) Lookup(name string, mode OffensiveMode) (database, error) {
+	dbs, err := c.Databases(mode)
+	if err != nil {
+		return database{}, err
+	}
+	for _, db := range dbs {
+		if db.Name == name {
+			return db, nil
+		}
+	}
+	return database{}, fmt.Errorf("%w: %q", ErrFileNotFound, name)
+}
+
+// scanDir lists the databases in dir. A database is any regular file with a
+// matching ".dat" index next to it.
+func scanDir(dir string, offensive bool) ([]database, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	indexed := make(map[string]bool)
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), fortunefile.IndexSuffix); ok {
+			indexed[name] = true
+		}
+	}
+
+	var dbs []database
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, fortunefile.IndexSuffix) || !indexed[name] {
+			continue
+		}
+		dbs = append(dbs, database{
+			Name:      name,
+			Path:      filepath.Join(dir, name),
+			Offensive: offensive,
+		})
+	}
+	return dbs, nil
+}
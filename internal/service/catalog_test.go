@@ -0,0 +1,131 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatalogOffensiveMode(t *testing.T) {
+	testCases := []struct {
+		name     string
+		safeMode bool
+		opts     FortuneOptions
+		expected OffensiveMode
+		err      error
+	}{
+		{name: "Default excludes", opts: FortuneOptions{}, expected: OffensiveExclude},
+		{name: "All includes", opts: FortuneOptions{All: true}, expected: OffensiveInclude},
+		{name: "Explicit only", opts: FortuneOptions{Offensive: OffensiveOnly}, expected: OffensiveOnly},
+		{name: "Safe mode narrows all", safeMode: true, opts: FortuneOptions{All: true}, expected: OffensiveExclude},
+		{name: "Safe mode allows exclude", safeMode: true, opts: FortuneOptions{Offensive: OffensiveExclude}, expected: OffensiveExclude},
+		{name: "Safe mode rejects include", safeMode: true, opts: FortuneOptions{Offensive: OffensiveInclude}, err: ErrOffensiveDisabled},
+		{name: "Safe mode rejects only", safeMode: true, opts: FortuneOptions{Offensive: OffensiveOnly}, err: ErrOffensiveDisabled},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mode, err := newTestCatalog("", tc.safeMode).offensiveMode(tc.opts)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, mode)
+		})
+	}
+}
+
+func TestCatalogDatabases(t *testing.T) {
+	dir := newTestCorpus(t)
+	catalog := newTestCatalog(dir, false)
+
+	names := func(mode OffensiveMode) []string {
+		dbs, err := catalog.Databases(mode)
+		require.NoError(t, err)
+		var out []string
+		for _, db := range dbs {
+			out = append(out, db.Name)
+		}
+		return out
+	}
+
+	assert.Equal(t, []string{"animals", "science"}, names(OffensiveExclude))
+	assert.Equal(t, []string{"animals", "rude", "science"}, names(OffensiveInclude))
+	assert.Equal(t, []string{"rude"}, names(OffensiveOnly))
+
+	// A name present in both trees resolves to the regular database unless
+	// offensive content is asked for explicitly.
+	writeTestDatabase(t, filepath.Join(dir, testOffensiveDir), "animals", []string{"rude animal"}, 0)
+
+	db, err := catalog.Lookup("animals", OffensiveInclude)
+	require.NoError(t, err)
+	assert.False(t, db.Offensive)
+
+	db, err = catalog.Lookup("animals", OffensiveOnly)
+	require.NoError(t, err)
+	assert.True(t, db.Offensive)
+
+	_, err = catalog.Lookup("rude", OffensiveExclude)
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
+
+func TestCatalogSafeModeHidesOffensive(t *testing.T) {
+	catalog := newTestCatalog(newTestCorpus(t), true)
+
+	dbs, err := catalog.Databases(OffensiveOnly)
+	require.NoError(t, err)
+	assert.Empty(t, dbs)
+
+	files, err := catalog.Files(FileQuery{})
+	require.NoError(t, err)
+	for _, f := range files {
+		assert.False(t, f.Offensive)
+	}
+}
+
+func TestCatalogPrecedence(t *testing.T) {
+	local := t.TempDir()
+	system := newTestCorpus(t)
+
+	writeTestDatabase(t, local, "science", []string{"Local science."}, 0)
+	writeTestDatabase(t, local, "local-only", []string{"Only here."}, 0)
+	writeTestDatabase(t, filepath.Join(local, "nsfw"), "rude", []string{"Local rude."}, 0)
+
+	catalog := NewCatalog([]Directory{
+		{Path: filepath.Join(t.TempDir(), "missing")},
+		{Path: local, OffensiveDir: "nsfw"},
+		{Path: system, OffensiveDir: testOffensiveDir},
+	}, false, nil)
+
+	dbs, err := catalog.Databases(OffensiveInclude)
+	require.NoError(t, err)
+
+	got := make(map[string]string)
+	for _, db := range dbs {
+		got[db.Name] = db.Dir
+	}
+	assert.Equal(t, map[string]string{
+		"animals":    system,
+		"local-only": local,
+		"rude":       local,
+		"science":    local,
+	}, got, "earlier directories win name collisions")
+
+	db, err := catalog.Lookup("science", OffensiveExclude)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(local, "science"), db.Path)
+
+	db, err = catalog.Lookup("rude", OffensiveOnly)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(local, "nsfw", "rude"), db.Path)
+}
+
+func TestCatalogNoReadableDirectory(t *testing.T) {
+	catalog := NewCatalog([]Directory{{Path: filepath.Join(t.TempDir(), "missing")}}, false, nil)
+
+	_, err := catalog.Databases(OffensiveExclude)
+	assert.Error(t, err)
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Period is how long a fortune of the day stays the same.
+type Period string
+
+const (
+	PeriodHour Period = "hour"
+	PeriodDay  Period = "day"
+	PeriodWeek Period = "week"
+)
+
+// Valid reports whether p is a known period.
+func (p Period) Valid() bool {
+	switch p {
+	case PeriodHour, PeriodDay, PeriodWeek:
+		return true
+	}
+	return false
+}
+
+// Bucket returns the period containing t, in t's location: a label naming
+// it, and the times it starts and ends. Weeks start on Monday, as in ISO
+// 8601. The label depends only on local wall time, so regions that share a
+// calendar date share a fortune, each switching at its own midnight.
+func (p Period) Bucket(t time.Time) (label string, start, end time.Time) {
+	y, m, d := t.Date()
+	loc := t.Location()
+
+	switch p {
+	case PeriodHour:
+		// Counted back from the instant rather than rebuilt from the wall
+		// clock, which would put the repeated hour of a DST change in its
+		// first occurrence.
+		start = t.Add(-(time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())))
+		return start.Format("2006-01-02T15"), start, start.Add(time.Hour)
+	case PeriodWeek:
+		offset := (int(t.Weekday()) + 6) % 7
+		start = time.Date(y, m, d-offset, 0, 0, 0, 0, loc)
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week), start, time.Date(y, m, d-offset+7, 0, 0, 0, 0, loc)
+	default:
+		start = time.Date(y, m, d, 0, 0, 0, 0, loc)
+		return start.Format("2006-01-02"), start, time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+	}
+}
+
+// DailySeed builds the selection seed for a fortune of the day from the
+// period label and the selection options, so that different option sets
+// get their own fortune for the same day.
+func DailySeed(label string, opts FortuneOptions) string {
+	opts.Seed = ""
+	encoded, _ := json.Marshal(opts)
+	sum := sha256.Sum256([]byte(label + "\x00" + string(encoded)))
+	return "daily-" + label + "-" + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeriodBucket(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		period        Period
+		at            time.Time
+		expectedLabel string
+		expectedStart time.Time
+		expectedEnd   time.Time
+	}{
+		{
+			name:          "Day",
+			period:        PeriodDay,
+			at:            time.Date(2026, 10, 16, 13, 45, 0, 0, time.UTC),
+			expectedLabel: "2026-10-16",
+			expectedStart: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC),
+			expectedEnd:   time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "Hour",
+			period:        PeriodHour,
+			at:            time.Date(2026, 10, 16, 13, 45, 0, 0, time.UTC),
+			expectedLabel: "2026-10-16T13",
+			expectedStart: time.Date(2026, 10, 16, 13, 0, 0, 0, time.UTC),
+			expectedEnd:   time.Date(2026, 10, 16, 14, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "Week starts on Monday",
+			period:        PeriodWeek,
+			at:            time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC),
+			expectedLabel: "2026-W42",
+			expectedStart: time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC),
+			expectedEnd:   time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "Local midnight",
+			period:        PeriodDay,
+			at:            time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC).In(newYork),
+			expectedLabel: "2026-10-16",
+			expectedStart: time.Date(2026, 10, 16, 0, 0, 0, 0, newYork),
+			expectedEnd:   time.Date(2026, 10, 17, 0, 0, 0, 0, newYork),
+		},
+		{
+			name:          "Daylight saving ends",
+			period:        PeriodDay,
+			at:            time.Date(2026, 11, 1, 12, 0, 0, 0, newYork),
+			expectedLabel: "2026-11-01",
+			expectedStart: time.Date(2026, 11, 1, 0, 0, 0, 0, newYork),
+			expectedEnd:   time.Date(2026, 11, 2, 0, 0, 0, 0, newYork),
+		},
+		{
+			name:          "Repeated hour",
+			period:        PeriodHour,
+			at:            time.Date(2026, 11, 1, 6, 30, 0, 0, time.UTC).In(newYork),
+			expectedLabel: "2026-11-01T01",
+			expectedStart: time.Date(2026, 11, 1, 6, 0, 0, 0, time.UTC),
+			expectedEnd:   time.Date(2026, 11, 1, 7, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			label, start, end := tc.period.Bucket(tc.at)
+			assert.Equal(t, tc.expectedLabel, label)
+			assert.True(t, tc.expectedStart.Equal(start), "start %v", start)
+			assert.True(t, tc.expectedEnd.Equal(end), "end %v", end)
+		})
+	}
+
+	_, start, end := PeriodDay.Bucket(time.Date(2026, 11, 1, 12, 0, 0, 0, newYork))
+	assert.Equal(t, 25*time.Hour, end.Sub(start))
+}
+
+func TestPeriodValid(t *testing.T) {
+	assert.True(t, PeriodHour.Valid())
+	assert.True(t, PeriodDay.Valid())
+	assert.True(t, PeriodWeek.Valid())
+	assert.False(t, Period("month").Valid())
+	assert.False(t, Period("").Valid())
+}
+
+func TestDailySeed(t *testing.T) {
+	opts := FortuneOptions{Files: []string{"animals"}}
+
+	assert.Equal(t, DailySeed("2026-10-16", opts), DailySeed("2026-10-16", opts))
+	assert.NotEqual(t, DailySeed("2026-10-16", opts), DailySeed("2026-10-17", opts))
+	assert.NotEqual(t, DailySeed("2026-10-16", opts), DailySeed("2026-10-16", FortuneOptions{}))
+
+	seeded := opts
+	seeded.Seed = "ignored"
+	assert.Equal(t, DailySeed("2026-10-16", opts), DailySeed("2026-10-16", seeded))
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Denylist retires fortunes server-wide, without touching the fortune
+// files: a fortune with one of its IDs, or whose text matches one of its
+// patterns, is never served.
+type Denylist struct {
+	ids      map[string]bool
+	patterns []*regexp.Regexp
+}
+
+// NewDenylist builds a denylist from fortune IDs and regular expressions.
+// It rejects malformed IDs and patterns, which would otherwise retire
+// nothing without anyone noticing.
+func NewDenylist(ids, patterns []string) (*Denylist, error) {
+	d := &Denylist{ids: make(map[string]bool, len(ids))}
+	for _, id := range ids {
+		if _, ok := parseFortuneID(id); !ok {
+			return nil, fmt.Errorf("denylist: %q is not a fortune ID", id)
+		}
+		d.ids[id] = true
+	}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("denylist: %w", err)
+		}
+		d.patterns = append(d.patterns, re)
+	}
+	return d, nil
+}
+
+// Len returns the number of IDs and patterns in the denylist.
+func (d *Denylist) Len() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.ids) + len(d.patterns)
+}
+
+// denies reports whether the fortune with the given ID and text is retired.
+func (d *Denylist) denies(id, text string) bool {
+	if d == nil {
+		return false
+	}
+	if d.ids[id] {
+		return true
+	}
+	text = strings.TrimSpace(text)
+	for _, re := range d.patterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded reports whether a fortune of db is left out of a selection,
+// either by opts.ExcludeIDs or by the catalog's denylist. Excluded files
+// never get this far; selectDatabases drops them.
+func (c *Catalog) excluded(db database, text string, opts FortuneOptions) bool {
+	if len(opts.ExcludeIDs) == 0 && !c.hasDenylist() {
+		return false
+	}
+	id := fortuneID(db, text)
+	for _, excluded := range opts.ExcludeIDs {
+		if id == excluded {
+			return true
+		}
+	}
+	return c.retired(id, text)
+}
+
+// hasDenylist reports whether the catalog retires any fortunes.
+func (c *Catalog) hasDenylist() bool {
+	return c != nil && c.denylist.Len() > 0
+}
+
+// retired reports whether the catalog's denylist retires the fortune with
+// the given ID and text.
+func (c *Catalog) retired(id, text string) bool {
+	return c != nil && c.denylist.denies(id, text)
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNewDenylist(t *testing.T) {
+	id := fortuneID(database{Name: "animals"}, "The cat sat on the mat.")
+
+	d, err := NewDenylist([]string{id}, []string{`(?i)\bcats?\b`})
+	require.NoError(t, err)
+	assert.Equal(t, 2, d.Len())
+
+	_, err = NewDenylist([]string{"not an id"}, nil)
+	assert.Error(t, err)
+
+	_, err = NewDenylist(nil, []string{"(unclosed"})
+	assert.Error(t, err)
+
+	var none *Denylist
+	assert.Zero(t, none.Len())
+	assert.False(t, none.denies(id, "The cat sat on the mat."))
+}
+
+func TestDenylistDenies(t *testing.T) {
+	retired := fortuneID(database{Name: "science"}, "E = mc^2")
+	d, err := NewDenylist([]string{retired}, []string{`^Entropy`})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		db       database
+		text     string
+		expected bool
+	}{
+		{name: "Retired ID", db: database{Name: "science"}, text: "E = mc^2\n", expected: true},
+		{name: "Same text in another file", db: database{Name: "physics"}, text: "E = mc^2", expected: false},
+		{name: "Matching pattern", db: database{Name: "science"}, text: "\nEntropy always increases.", expected: true},
+		{name: "Neither", db: database{Name: "animals"}, text: "The cat sat on the mat.", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, d.denies(fortuneID(tc.db, tc.text), tc.text))
+		})
+	}
+}
+
+func TestCatalogExcluded(t *testing.T) {
+	animals := database{Name: "animals"}
+	dog := "A dog is a man's best friend."
+
+	var catalog *Catalog
+	assert.False(t, catalog.excluded(animals, dog, FortuneOptions{}))
+	assert.True(t, catalog.excluded(animals, dog, FortuneOptions{ExcludeIDs: []string{fortuneID(animals, dog)}}))
+	assert.False(t, catalog.excluded(database{Name: "pets"}, dog, FortuneOptions{ExcludeIDs: []string{fortuneID(animals, dog)}}))
+
+	d, err := NewDenylist(nil, []string{"dog"})
+	require.NoError(t, err)
+	catalog = NewCatalog(nil, false, d)
+	assert.True(t, catalog.excluded(animals, dog, FortuneOptions{}))
+}
+
+func TestNativeDenylist(t *testing.T) {
+	dir := newTestCorpus(t)
+	cat := fortuneID(database{Name: "animals"}, "The cat sat on the mat.")
+	d, err := NewDenylist([]string{cat}, []string{`^Entropy`})
+	require.NoError(t, err)
+	s := NewNativeService(NewCatalog([]Directory{{Path: dir, OffensiveDir: testOffensiveDir}}, false, d), nil, zap.NewNop())
+	ctx := context.Background()
+
+	resp, err := s.GetFortunes(ctx, FortuneOptions{}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 3, resp.Count, "retired fortunes are never drawn")
+	for _, f := range resp.Fortunes {
+		assert.NotEqual(t, cat, f.ID)
+		assert.NotEqual(t, "Entropy always increases.", f.Fortune)
+	}
+
+	_, err = s.GetFortuneByID(ctx, cat)
+	assert.ErrorIs(t, err, ErrFortuneNotFound)
+
+	search, err := s.SearchFortunes(ctx, "cat", FortuneOptions{IgnoreCase: true}, SearchPage{})
+	require.NoError(t, err)
+	require.Equal(t, 1, search.Count)
+	assert.Equal(t, "animals", search.Matches[0].SourceFile)
+	assert.NotEqual(t, cat, search.Matches[0].ID)
+
+	query, err := s.QueryFortunes(ctx, "entropy", FortuneOptions{}, SearchPage{})
+	require.NoError(t, err)
+	assert.Zero(t, query.Count)
+}
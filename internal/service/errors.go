@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Errors returned by both backends. Callers should test for them with
+// errors.Is, as they are usually wrapped with more detail.
+var (
+	// ErrFileNotFound means a named fortune database does not exist.
+	ErrFileNotFound = errors.New("fortune file not found")
+
+	// ErrFortuneNotFound means no fortune has the requested ID.
+	ErrFortuneNotFound = errors.New("fortune not found")
+
+	// ErrNoMatch means no fortune satisfied the requested options.
+	ErrNoMatch = errors.New("no fortune matched")
+
+	// ErrBadPattern means a search pattern is not a valid regular expression.
+	ErrBadPattern = errors.New("invalid search pattern")
+
+	// ErrOffensiveDisabled means offensive fortunes were requested while the
+	// server runs in safe mode.
+	ErrOffensiveDisabled = errors.New("offensive fortunes are disabled")
+
+	// ErrBackendTimeout means the lookup was abandoned at its deadline.
+	ErrBackendTimeout = errors.New("fortune backend timed out")
+
+	// ErrBackendUnavailable means the fortune binary or databases could not
+	// be reached at all.
+	ErrBackendUnavailable = errors.New("fortune backend unavailable")
+)
+
+// contextError converts a context error into the matching service error.
+// The original error is kept in the chain, so errors.Is still recognises
+// context.DeadlineExceeded and context.Canceled.
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrBackendTimeout, err)
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package service
+
+// Facets a search can count its matches by.
+const (
+	FacetFile   = "file"
+	FacetLength = "length"
+)
+
+// Buckets of the length facet. Short fortunes are the ones FortuneOptions.Short
+// selects; medium and long ones together are what FortuneOptions.Long selects,
+// split at twice the Length threshold.
+const (
+	LengthShort  = "short"
+	LengthMedium = "medium"
+	LengthLong   = "long"
+)
+
+// SearchFacets counts every match of a search, not just those on the page.
+// Only the facets the SearchPage asked for are filled in. File maps each
+// source file with a match to its number of matches; Length always holds
+// all three buckets.
+type SearchFacets struct {
+	File   map[string]int `json:"file,omitempty"`
+	Length map[string]int `json:"length,omitempty"`
+}
+
+// validFacet reports whether name is a facet searches can count.
+func validFacet(name string) bool {
+	return name == FacetFile || name == FacetLength
+}
+
+// countFacets counts results by the requested facets. It returns nil if
+// none were requested.
+func countFacets(results []ranked, facets []string, opts FortuneOptions) *SearchFacets {
+	if len(facets) == 0 {
+		return nil
+	}
+
+	out := &SearchFacets{}
+	for _, facet := range facets {
+		switch facet {
+		case FacetFile:
+			out.File = make(map[string]int)
+		case FacetLength:
+			out.Length = map[string]int{LengthShort: 0, LengthMedium: 0, LengthLong: 0}
+		}
+	}
+	for _, r := range results {
+		if out.File != nil {
+			out.File[r.match.SourceFile]++
+		}
+		if out.Length != nil {
+			out.Length[lengthBucket(r.length, opts)]++
+		}
+	}
+	return out
+}
+
+// lengthBucket returns the length facet bucket of a fortune length bytes
+// long, measured against the same threshold as the short and long filters.
+func lengthBucket(length int, opts FortuneOptions) string {
+	limit := shortLength(opts)
+	switch {
+	case length <= limit:
+		return LengthShort
+	case length <= 2*limit:
+		return LengthMedium
+	}
+	return LengthLong
+}
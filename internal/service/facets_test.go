@@ -0,0 +1,67 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLengthBucket(t *testing.T) {
+	testCases := []struct {
+		length   int
+		opts     FortuneOptions
+		expected string
+	}{
+		{length: 1, expected: LengthShort},
+		{length: defaultShortLength, expected: LengthShort},
+		{length: defaultShortLength + 1, expected: LengthMedium},
+		{length: 2 * defaultShortLength, expected: LengthMedium},
+		{length: 2*defaultShortLength + 1, expected: LengthLong},
+		{length: 10, opts: FortuneOptions{Length: 5}, expected: LengthMedium},
+		{length: 11, opts: FortuneOptions{Length: 5}, expected: LengthLong},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, lengthBucket(tc.length, tc.opts), "length %d, threshold %d", tc.length, tc.opts.Length)
+	}
+}
+
+func TestCountFacets(t *testing.T) {
+	results := []ranked{
+		{match: SearchMatch{FortuneResponse: FortuneResponse{SourceFile: "animals"}}, length: 20},
+		{match: SearchMatch{FortuneResponse: FortuneResponse{SourceFile: "animals"}}, length: 200},
+		{match: SearchMatch{FortuneResponse: FortuneResponse{SourceFile: "science"}}, length: 30},
+	}
+
+	assert.Nil(t, countFacets(results, nil, FortuneOptions{}))
+
+	facets := countFacets(results, []string{FacetFile}, FortuneOptions{})
+	require.NotNil(t, facets)
+	assert.Equal(t, map[string]int{"animals": 2, "science": 1}, facets.File)
+	assert.Nil(t, facets.Length)
+
+	facets = countFacets(results, []string{FacetLength}, FortuneOptions{})
+	require.NotNil(t, facets)
+	assert.Nil(t, facets.File)
+	assert.Equal(t, map[string]int{LengthShort: 2, LengthMedium: 1, LengthLong: 0}, facets.Length)
+
+	facets = countFacets(nil, []string{FacetFile, FacetLength}, FortuneOptions{})
+	assert.Empty(t, facets.File)
+	assert.Equal(t, map[string]int{LengthShort: 0, LengthMedium: 0, LengthLong: 0}, facets.Length, "every bucket is listed")
+}
+
+func TestPageResults_Facets(t *testing.T) {
+	results := testResults()
+	for i := range results {
+		results[i].match.SourceFile = []string{"a", "b"}[i%2]
+		results[i].length = len(results[i].match.Fortune)
+	}
+
+	resp, err := pageResults(results, "q\x00cat", FortuneOptions{Length: 10}, SearchPage{Sort: SearchByFile, Limit: 1, Facets: []string{FacetFile, FacetLength}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.Count)
+	require.NotNil(t, resp.Facets)
+	assert.Equal(t, map[string]int{"a": 2, "b": 2}, resp.Facets.File, "facets count every match, not just the page")
+	assert.Equal(t, map[string]int{LengthShort: 2, LengthMedium: 1, LengthLong: 1}, resp.Facets.Length)
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"fortune-api/internal/fortunefile"
+
+	"go.uber.org/zap"
+)
+
+// FileInfo describes a fortune file. The counts and lengths come from its
+// strfile header; lengths are in bytes.
+type FileInfo struct {
+	Name           string    `json:"name"`
+	Offensive      bool      `json:"offensive"`
+	Directory      string    `json:"directory"`
+	Count          int       `json:"count"`
+	LongestLength  int       `json:"longest_length"`
+	ShortestLength int       `json:"shortest_length"`
+	Size           int64     `json:"size"`
+	Modified       time.Time `json:"modified"`
+}
+
+// Sort keys accepted in FileQuery.Sort.
+const (
+	SortByName     = "name"
+	SortByCount    = "count"
+	SortBySize     = "size"
+	SortByModified = "modified"
+	SortByLongest  = "longest"
+	SortByShortest = "shortest"
+)
+
+// FileQuery narrows and orders a file listing. The zero value lists every
+// file by name.
+type FileQuery struct {
+	Prefix     string
+	Sort       string
+	Descending bool
+}
+
+// Validate rejects unknown sort keys.
+func (q FileQuery) Validate() error {
+	if _, ok := fileOrderings[q.Sort]; !ok && q.Sort != "" {
+		verr := &ValidationError{}
+		verr.Add("sort", q.Sort, CodeInvalidValue,
+			"must be one of name, count, size, modified, longest, shortest")
+		return verr
+	}
+	return nil
+}
+
+// fileOrderings maps sort keys to comparisons. Ties are broken by name.
+var fileOrderings = map[string]func(a, b FileInfo) int{
+	SortByName:     func(a, b FileInfo) int { return 0 },
+	SortByCount:    func(a, b FileInfo) int { return a.Count - b.Count },
+	SortBySize:     func(a, b FileInfo) int { return cmpInt64(a.Size, b.Size) },
+	SortByModified: func(a, b FileInfo) int { return a.Modified.Compare(b.Modified) },
+	SortByLongest:  func(a, b FileInfo) int { return a.LongestLength - b.LongestLength },
+	SortByShortest: func(a, b FileInfo) int { return a.ShortestLength - b.ShortestLength },
+}
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Files lists the databases the catalog exposes that match q, with their
+// metadata.
+func (c *Catalog) Files(q FileQuery) ([]FileInfo, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	dbs, err := c.Databases(OffensiveInclude)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not read fortune directory: %w", ErrBackendUnavailable, err)
+	}
+
+	files := make([]FileInfo, 0, len(dbs))
+	for _, db := range dbs {
+		if !strings.HasPrefix(db.Name, q.Prefix) {
+			continue
+		}
+		info, err := describe(db)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, info)
+	}
+
+	compare := fileOrderings[q.Sort]
+	if compare == nil {
+		compare = fileOrderings[SortByName]
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if q.Descending {
+			a, b = b, a
+		}
+		if d := compare(a, b); d != 0 {
+			return d < 0
+		}
+		return a.Name < b.Name
+	})
+	return files, nil
+}
+
+// File returns the metadata of a single database. If both a regular and an
+// offensive database carry the name, the regular one is described.
+func (c *Catalog) File(name string) (*FileInfo, error) {
+	if !validFileName(name) {
+		return nil, fmt.Errorf("%w: %q", ErrFileNotFound, name)
+	}
+
+	db, err := c.Lookup(name, OffensiveInclude)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := describe(db)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// describe reads the strfile header and file status of db.
+func describe(db database) (FileInfo, error) {
+	file, err := fortunefile.Open(db.Path)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("could not open fortune file: %w", err)
+	}
+
+	stat, err := os.Stat(db.Path)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("could not stat fortune file: %w", err)
+	}
+
+	return FileInfo{
+		Name:           db.Name,
+		Offensive:      db.Offensive,
+		Directory:      db.Dir,
+		Count:          file.Len(),
+		LongestLength:  int(file.Index.LongLen),
+		ShortestLength: int(file.Index.ShortLen),
+		Size:           stat.Size(),
+		Modified:       stat.ModTime().UTC(),
+	}, nil
+}
+
+// listFiles and getFile serve the file catalogue for both backends; it comes
+// from the strfile headers, so the fortune binary is never involved.
+func listFiles(ctx context.Context, catalog *Catalog, query FileQuery, logger *zap.Logger) ([]FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(err)
+	}
+
+	files, err := catalog.Files(query)
+	if err != nil && !isValidationError(err) {
+		logger.Error("Failed to list fortune files", zap.Error(err), zap.Strings("directories", catalog.Paths()))
+	}
+	return files, err
+}
+
+func getFile(ctx context.Context, catalog *Catalog, name string, logger *zap.Logger) (*FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(err)
+	}
+
+	info, err := catalog.File(name)
+	if err != nil && !errors.Is(err, ErrFileNotFound) {
+		logger.Error("Failed to describe fortune file", zap.Error(err), zap.String("file", name))
+	}
+	return info, err
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func fileNames(files []FileInfo) []string {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Name
+	}
+	return names
+}
+
+func TestCatalogFileMetadata(t *testing.T) {
+	dir := newTestCorpus(t)
+	catalog := newTestCatalog(dir, false)
+
+	info, err := catalog.File("science")
+	require.NoError(t, err)
+
+	stat, err := os.Stat(filepath.Join(dir, "science"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "science", info.Name)
+	assert.False(t, info.Offensive)
+	assert.Equal(t, dir, info.Directory)
+	assert.Equal(t, 2, info.Count)
+	assert.Equal(t, len("Entropy always increases.\n"), info.LongestLength)
+	assert.Equal(t, len("E = mc^2\n"), info.ShortestLength)
+	assert.Equal(t, stat.Size(), info.Size)
+	assert.True(t, stat.ModTime().Equal(info.Modified))
+
+	info, err = catalog.File("rude")
+	require.NoError(t, err)
+	assert.True(t, info.Offensive)
+	assert.Equal(t, 1, info.Count)
+}
+
+func TestCatalogFileNotFound(t *testing.T) {
+	catalog := newTestCatalog(newTestCorpus(t), true)
+
+	for _, name := range []string{"missing", "README", "../animals", "rude"} {
+		_, err := catalog.File(name)
+		assert.ErrorIs(t, err, ErrFileNotFound, name)
+	}
+}
+
+func TestCatalogFilesQuery(t *testing.T) {
+	dir := newTestCorpus(t)
+	catalog := newTestCatalog(dir, false)
+
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "science"), old, old))
+
+	testCases := []struct {
+		name     string
+		query    FileQuery
+		expected []string
+	}{
+		{name: "Default", query: FileQuery{}, expected: []string{"animals", "rude", "science"}},
+		{name: "Name descending", query: FileQuery{Sort: SortByName, Descending: true}, expected: []string{"science", "rude", "animals"}},
+		{name: "Prefix", query: FileQuery{Prefix: "s"}, expected: []string{"science"}},
+		{name: "No prefix match", query: FileQuery{Prefix: "zzz"}, expected: []string{}},
+		{name: "Count", query: FileQuery{Sort: SortByCount}, expected: []string{"rude", "science", "animals"}},
+		{name: "Count descending", query: FileQuery{Sort: SortByCount, Descending: true}, expected: []string{"animals", "science", "rude"}},
+		{name: "Longest", query: FileQuery{Sort: SortByLongest, Descending: true}, expected: []string{"animals", "science", "rude"}},
+		{name: "Shortest", query: FileQuery{Sort: SortByShortest}, expected: []string{"science", "rude", "animals"}},
+		{name: "Size", query: FileQuery{Sort: SortBySize}, expected: []string{"rude", "science", "animals"}},
+		{name: "Modified", query: FileQuery{Sort: SortByModified}, expected: []string{"science", "animals", "rude"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			files, err := catalog.Files(tc.query)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, fileNames(files))
+		})
+	}
+}
+
+func TestCatalogFilesInvalidSort(t *testing.T) {
+	catalog := newTestCatalog(newTestCorpus(t), false)
+
+	_, err := catalog.Files(FileQuery{Sort: "colour"})
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, "sort", verr.Errors[0].Field)
+	assert.Equal(t, CodeInvalidValue, verr.Errors[0].Code)
+}
+
+func TestGetFileCancelled(t *testing.T) {
+	catalog := newTestCatalog(newTestCorpus(t), false)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := getFile(ctx, catalog, "animals", zap.NewNop())
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -1,148 +1,548 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"os"
+	"io/fs"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"fortune-api/internal/fortunefile"
 
 	"go.uber.org/zap"
 )
 
 // FortuneServiceInterface defines the contract for our fortune service.
 // Handlers will depend on this interface, not the concrete implementation.
+// Every method stops early and returns the context's error once ctx is
+// cancelled or its deadline passes.
 type FortuneServiceInterface interface {
-	GetFortune(opts FortuneOptions) (*FortuneResponse, error)
-	ListFiles() ([]string, error)
-	SearchFortunes(pattern string, opts FortuneOptions) (*SearchResponse, error)
+	GetFortune(ctx context.Context, opts FortuneOptions) (*FortuneResponse, error)
+	GetFortunes(ctx context.Context, opts FortuneOptions, count int) (*FortunesResponse, error)
+	GetFortuneByID(ctx context.Context, id string) (*FortuneResponse, error)
+	ListFiles(ctx context.Context, query FileQuery) ([]FileInfo, error)
+	GetFile(ctx context.Context, name string) (*FileInfo, error)
+	Probabilities(ctx context.Context, opts FortuneOptions) ([]FileProbability, error)
+	SearchFortunes(ctx context.Context, pattern string, opts FortuneOptions, page SearchPage) (*SearchResponse, error)
+	QueryFortunes(ctx context.Context, query string, opts FortuneOptions, page SearchPage) (*SearchResponse, error)
+	SimilarFortunes(ctx context.Context, id string, opts FortuneOptions, count int) (*SimilarResponse, error)
 }
 
 // Ensure FortuneService implements the interface.
 // This is a compile-time check.
-var _ FortuneServiceInterface = (*FortuneService)(nil)
+var (
+	_ FortuneServiceInterface = (*FortuneService)(nil)
+	_ IndexStatser            = (*FortuneService)(nil)
+	_ SearchStreamer          = (*FortuneService)(nil)
+)
 
 type FortuneService struct {
 	fortunePath string
+	catalog     *Catalog
 	logger      *zap.Logger
+
+	// inProcess serves what the binary can't do: seeded and distinct draws.
+	inProcess *NativeService
 }
 
+// OffensiveMode controls whether offensive fortunes may be served.
+type OffensiveMode string
+
+const (
+	OffensiveExclude OffensiveMode = "exclude"
+	OffensiveInclude OffensiveMode = "include"
+	OffensiveOnly    OffensiveMode = "only"
+)
+
 type FortuneOptions struct {
-	All         bool     `json:"all"`
-	ShowCookie  bool     `json:"show_cookie"`
-	Equal       bool     `json:"equal"`
-	Long        bool     `json:"long"`
-	Short       bool     `json:"short"`
-	IgnoreCase  bool     `json:"ignore_case"`
-	Wait        bool     `json:"wait"`
-	Length      int      `json:"length"`
-	Pattern     string   `json:"pattern"`
-	Files       []string `json:"files"`
-	Percentages []string `json:"percentages"`
+	All         bool          `json:"all"`
+	Offensive   OffensiveMode `json:"offensive,omitempty"`
+	ShowCookie  bool          `json:"show_cookie"`
+	Equal       bool          `json:"equal"`
+	Long        bool          `json:"long"`
+	Short       bool          `json:"short"`
+	IgnoreCase  bool          `json:"ignore_case"`
+	Wait        bool          `json:"wait"`
+	Length      int           `json:"length"`
+	Pattern     string        `json:"pattern"`
+	Match       string        `json:"match,omitempty"`
+	Files       []string      `json:"files"`
+	Percentages []string      `json:"percentages"`
+
+	// Seed makes the selection deterministic: the same seed and options
+	// over the same corpus always pick the same fortune.
+	Seed string `json:"seed,omitempty"`
+
+	// Fuzzy lets every term of a search query match words a few edits
+	// away. FuzzyDistance caps the edits per term; zero scales the cap with
+	// the length of the term.
+	Fuzzy         bool `json:"fuzzy,omitempty"`
+	FuzzyDistance int  `json:"fuzzy_distance,omitempty"`
+
+	// MinLength, MaxLength, MaxLines and MaxWidth fit fortunes into a fixed
+	// space. Unlike Length they measure the fortune as returned, in
+	// characters; MaxWidth bounds its longest line, with tabs expanded to
+	// every eighth column. Zero leaves a limit off.
+	MinLength int `json:"min_length,omitempty"`
+	MaxLength int `json:"max_length,omitempty"`
+	MaxLines  int `json:"max_lines,omitempty"`
+	MaxWidth  int `json:"max_width,omitempty"`
+
+	// ExcludeFiles and ExcludeIDs leave databases and single fortunes out
+	// of the selection. Excluded databases are dropped before the rest are
+	// weighed, so they don't skew the odds of the others.
+	ExcludeFiles []string `json:"exclude_files,omitempty"`
+	ExcludeIDs   []string `json:"exclude_ids,omitempty"`
 }
 
+// FortuneResponse is a single fortune. ID is stable for as long as the
+// fortune's text and file name stay the same, and can be passed to
+// GetFortuneByID.
 type FortuneResponse struct {
+	ID         string `json:"id,omitempty"`
 	Fortune    string `json:"fortune"`
 	SourceFile string `json:"source_file,omitempty"`
+
+	// Seed replays this fortune when passed back in FortuneOptions. It is
+	// empty if the fortune was picked by the binary, which can't be seeded.
+	Seed string `json:"seed,omitempty"`
+}
+
+// FortunesResponse holds several distinct fortunes. Partial is set when
+// fewer than the requested number were available.
+type FortunesResponse struct {
+	Fortunes []FortuneResponse `json:"fortunes"`
+	Count    int               `json:"count"`
+	Partial  bool              `json:"partial"`
+	Seed     string            `json:"seed,omitempty"`
+}
+
+// SearchMatch is a fortune found by a search. Index is its position within
+// the source file, counting from zero, and Highlights are the byte ranges of
+// Fortune that the pattern matched. Fuzzy lists the query terms that only
+// matched approximately, and Score is the BM25 relevance of a query match.
+type SearchMatch struct {
+	FortuneResponse
+	Index      int          `json:"index"`
+	Highlights []Span       `json:"highlights"`
+	Fuzzy      []FuzzyMatch `json:"fuzzy,omitempty"`
+	Score      float64      `json:"score,omitempty"`
+}
+
+// FuzzyMatch is a query term that matched a different word of a fortune,
+// Distance edits away.
+type FuzzyMatch struct {
+	Term     string `json:"term"`
+	Matched  string `json:"matched"`
+	Distance int    `json:"distance"`
+}
+
+// Span is a half-open byte range [Start, End).
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
 }
 
+// SearchResponse is one page of the matches of a search, in the order its
+// SearchPage asked for. Count is the size of the page and Total the number
+// of matches in all; NextCursor fetches the following page and is empty on
+// the last. Facets counts all the matches, if asked to. Seed echoes the
+// requested seed for bug reports.
 type SearchResponse struct {
-	Matches []FortuneResponse `json:"matches"`
-	Count   int               `json:"count"`
+	Matches    []SearchMatch `json:"matches"`
+	Count      int           `json:"count"`
+	Total      int           `json:"total"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	Facets     *SearchFacets `json:"facets,omitempty"`
+	Seed       string        `json:"seed,omitempty"`
 }
 
-func NewFortuneService(fortunePath string, logger *zap.Logger) *FortuneService {
+// NewFortuneService creates the exec backend. Requested files are checked
+// against catalog before they are handed to the fortune binary. index serves
+// word queries and may be nil.
+func NewFortuneService(fortunePath string, catalog *Catalog, index *Index, logger *zap.Logger) *FortuneService {
 	return &FortuneService{
 		fortunePath: fortunePath,
+		catalog:     catalog,
 		logger:      logger,
+		inProcess:   NewNativeService(catalog, index, logger),
 	}
 }
 
-func (s *FortuneService) GetFortune(opts FortuneOptions) (*FortuneResponse, error) {
+// GetFortune always asks the binary for the cookie file with -c, which is how
+// the fortune is tied back to its database for its ID. The file is only
+// reported to the caller when ShowCookie is set. The binary can neither be
+// seeded, pick one fortune among matches, apply the size limits nor leave
+// out single fortunes, so such requests are served in process instead, as
+// is every request once the catalog has a denylist. Excluded files are
+// simply not passed to it.
+func (s *FortuneService) GetFortune(ctx context.Context, opts FortuneOptions) (*FortuneResponse, error) {
+	if opts.Seed != "" || opts.Match != "" || opts.hasSizeLimits() || len(opts.ExcludeIDs) > 0 || s.catalog.hasDenylist() {
+		return s.inProcess.GetFortune(ctx, opts)
+	}
+
+	opts, err := s.resolveFiles(opts)
+	if err != nil {
+		return nil, err
+	}
+	showCookie := opts.ShowCookie
+	if opts.Pattern == "" {
+		opts.ShowCookie = true
+	}
 	args := s.buildArgs(opts)
 
-	cmd := exec.Command(s.fortunePath, args...)
-	output, err := cmd.CombinedOutput()
+	output, err := s.command(ctx, args).Output()
 	if err != nil {
-		s.logger.Error("Fortune command failed",
-			zap.Error(err),
-			zap.String("command", s.fortunePath),
-			zap.Strings("args", args),
-			zap.String("output", string(output)))
-		return nil, fmt.Errorf("fortune command failed: %w", err)
+		if ctx.Err() == nil {
+			s.logger.Error("Fortune command failed",
+				zap.Error(err),
+				zap.String("command", s.fortunePath),
+				zap.Strings("args", args),
+				zap.String("stderr", exitStderr(err)))
+		}
+		return nil, s.commandError(ctx, err)
 	}
 
-	fortune := strings.TrimSpace(string(output))
+	cookie, fortune := parseCookie(string(output))
 	if fortune == "" {
-		return nil, errors.New("no fortune returned")
+		return nil, ErrNoMatch
 	}
 
 	response := &FortuneResponse{
 		Fortune: fortune,
 	}
-
-	// If show_cookie is enabled, we might have source file info
-	if opts.ShowCookie {
-		lines := strings.Split(fortune, "\n")
-		if len(lines) > 1 && strings.Contains(lines[len(lines)-1], "(") {
-			response.SourceFile = strings.Trim(lines[len(lines)-1], "()")
-			response.Fortune = strings.Join(lines[:len(lines)-1], "\n")
+	if db, ok := s.cookieDatabase(cookie); ok {
+		response.ID = fortuneID(db, fortune)
+		if showCookie {
+			response.SourceFile = db.Name
 		}
 	}
 
 	return response, nil
 }
 
-func (s *FortuneService) ListFiles() ([]string, error) {
-	// On Debian systems, fortune files are stored in this directory.
-	const fortuneDir = "/usr/share/games/fortunes/"
+// GetFortunes is served in process, since repeated runs of the binary can't
+// guarantee distinct fortunes.
+func (s *FortuneService) GetFortunes(ctx context.Context, opts FortuneOptions, count int) (*FortunesResponse, error) {
+	return s.inProcess.GetFortunes(ctx, opts, count)
+}
 
-	entries, err := os.ReadDir(fortuneDir)
+func (s *FortuneService) GetFortuneByID(ctx context.Context, id string) (*FortuneResponse, error) {
+	return fortuneByID(ctx, s.catalog, id, s.logger)
+}
+
+func (s *FortuneService) ListFiles(ctx context.Context, query FileQuery) ([]FileInfo, error) {
+	return listFiles(ctx, s.catalog, query, s.logger)
+}
+
+func (s *FortuneService) GetFile(ctx context.Context, name string) (*FileInfo, error) {
+	return getFile(ctx, s.catalog, name, s.logger)
+}
+
+// Probabilities is worked out from the strfile headers rather than by parsing
+// `fortune -f`, whose output goes to stderr and varies between versions.
+func (s *FortuneService) Probabilities(ctx context.Context, opts FortuneOptions) ([]FileProbability, error) {
+	return probabilities(ctx, s.catalog, opts, s.logger)
+}
+
+// QueryFortunes is answered from the index; the binary only knows regular
+// expressions.
+func (s *FortuneService) QueryFortunes(ctx context.Context, query string, opts FortuneOptions, page SearchPage) (*SearchResponse, error) {
+	return s.inProcess.QueryFortunes(ctx, query, opts, page)
+}
+
+// SimilarFortunes is answered from the index too.
+func (s *FortuneService) SimilarFortunes(ctx context.Context, id string, opts FortuneOptions, count int) (*SimilarResponse, error) {
+	return s.inProcess.SimilarFortunes(ctx, id, opts, count)
+}
+
+func (s *FortuneService) StreamQuery(ctx context.Context, query string, opts FortuneOptions, page SearchPage, fn MatchFunc) error {
+	return s.inProcess.StreamQuery(ctx, query, opts, page, fn)
+}
+
+func (s *FortuneService) IndexStats() (IndexStats, bool) {
+	return s.inProcess.IndexStats()
+}
+
+// SearchFortunes runs `fortune -m` once over every selected database, then
+// works out which file each match came from by finding its text, in order,
+// in the databases' fortunes. Only stdout is parsed; fortune writes a
+// "(file)" header to stderr that must not end up in the matches.
+func (s *FortuneService) SearchFortunes(ctx context.Context, pattern string, opts FortuneOptions, page SearchPage) (*SearchResponse, error) {
+	page, err := page.forPattern()
 	if err != nil {
-		s.logger.Error("Failed to read fortune directory", zap.Error(err), zap.String("directory", fortuneDir))
-		return nil, fmt.Errorf("could not read fortune directory: %w", err)
+		return nil, err
 	}
 
-	var files []string
-	for _, entry := range entries {
-		// We only want the actual data files, not directories or the index (.dat) files.
-		if !entry.IsDir() && !strings.HasSuffix(entry.Name(), ".dat") {
-			files = append(files, entry.Name())
-		}
+	var results []ranked
+	err = s.searchPattern(ctx, pattern, opts, func(r ranked) error {
+		results = append(results, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return pageResults(results, patternSearch(pattern), opts, page)
+}
 
-	return files, nil
+// StreamSearch passes on the matches once fortune has finished searching.
+func (s *FortuneService) StreamSearch(ctx context.Context, pattern string, opts FortuneOptions, page SearchPage, fn MatchFunc) error {
+	page, err := page.forPattern()
+	if err != nil {
+		return err
+	}
+	st, err := newStreamer(patternSearch(pattern), opts, page, fn)
+	if err != nil {
+		return err
+	}
+	err = s.searchPattern(ctx, pattern, opts, st.add)
+	return st.finish(err)
 }
 
-func (s *FortuneService) SearchFortunes(pattern string, opts FortuneOptions) (*SearchResponse, error) {
+// searchPattern calls fn with the matches of a pattern search in file
+// order. fortune searches every database in a single run; where each match
+// came from is then worked out in process. The size limits and the excluded
+// fortunes, which the binary doesn't know, are applied to what it finds.
+func (s *FortuneService) searchPattern(ctx context.Context, pattern string, opts FortuneOptions, fn func(ranked) error) error {
 	if pattern == "" {
-		return nil, errors.New("search pattern is required")
+		return fmt.Errorf("%w: pattern is required", ErrBadPattern)
+	}
+	// Plain words and phrases need no regular expressions, so the in-process
+	// index serves them when it is built.
+	if ok, err := searchIndexed(ctx, s.inProcess.index, s.catalog, pattern, opts, s.logger, fn); ok {
+		return err
 	}
 
-	// Force pattern search
-	opts.Pattern = pattern
-	args := s.buildArgs(opts)
+	// fortune has its own regular expressions, so a pattern Go can't parse
+	// may still be fine; it is only used for highlights, which are left
+	// empty without it. Leftmost-longest matching is what POSIX does.
+	re, reErr := compilePattern(pattern, opts.IgnoreCase)
+	if re != nil {
+		re.Longest()
+	}
 
-	cmd := exec.Command(s.fortunePath, args...)
-	output, err := cmd.CombinedOutput()
+	dbs, err := s.databases(opts)
 	if err != nil {
-		s.logger.Error("Fortune search command failed", zap.Error(err))
-		return nil, fmt.Errorf("fortune search failed: %w", err)
+		return err
+	}
+
+	// Force pattern search. -a/-o are settled by the catalog, and -c and -w
+	// make no sense for a search.
+	opts.Pattern = pattern
+	opts.All, opts.Offensive = false, ""
+	opts.ShowCookie, opts.Wait = false, false
+	opts.Percentages = nil
+	opts.Files = make([]string, len(dbs))
+	for i, db := range dbs {
+		opts.Files[i] = db.Path
 	}
 
+	output, err := s.search(ctx, s.buildArgs(opts))
+	if err != nil {
+		if reErr != nil && ctx.Err() == nil && !errors.Is(err, ErrBackendUnavailable) {
+			return fmt.Errorf("%w: %v", ErrBadPattern, reErr)
+		}
+		return err
+	}
 	matches := s.parseSearchResults(string(output))
+	if len(matches) == 0 {
+		return nil
+	}
+
+	fortunes, err := s.fortunesOf(ctx, dbs)
+	if err != nil {
+		return err
+	}
+	for _, r := range s.attribute(dbs, fortunes, matches, re) {
+		if !sizeAllowed(r.match.Fortune, opts) || s.catalog.excluded(r.db, r.match.Fortune, opts) {
+			continue
+		}
+		if err := fn(r.ranked); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// search runs one `fortune -m` invocation and returns its stdout. fortune
+// exits with status 1 when it found something, so that only counts as a
+// failure if nothing was printed.
+func (s *FortuneService) search(ctx context.Context, args []string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := s.command(ctx, args)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && stdout.Len() > 0 && ctx.Err() == nil {
+		err = nil
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.Error("Fortune search command failed",
+				zap.Error(err),
+				zap.Strings("args", args),
+				zap.String("stderr", stderr.String()))
+		}
+		return nil, s.commandError(ctx, err)
+	}
+	return stdout.Bytes(), nil
+}
 
-	return &SearchResponse{
-		Matches: matches,
-		Count:   len(matches),
-	}, nil
+// attributed is a match found by the binary, with the database it is from.
+type attributed struct {
+	ranked
+	db database
+}
+
+// attribute works out where each match came from. fortune prints matches in
+// the order of the databases it was given, and in file order within each,
+// so the matches are looked up in a single pass over fortunes, the contents
+// of dbs. A match that can't be found, because its file changed since, is
+// dropped: there is no ID it could be fetched by.
+func (s *FortuneService) attribute(dbs []database, fortunes map[string][]string, matches []SearchMatch, re *regexp.Regexp) []attributed {
+	results := make([]attributed, 0, len(matches))
+	d, next := 0, 0
+	for _, m := range matches {
+		m.Index = -1
+		m.Highlights = []Span{}
+		if re != nil {
+			m.Highlights = highlights(re, m.Fortune)
+		}
+		length := len(m.Fortune)
+
+		for i := d; i < len(dbs) && m.Index < 0; i++ {
+			start := 0
+			if i == d {
+				start = next
+			}
+			texts := fortunes[dbs[i].Path]
+			for j := start; j < len(texts); j++ {
+				if strings.TrimSpace(texts[j]) == m.Fortune {
+					m.Index, d, next = j, i, j+1
+					length = len(texts[j])
+					break
+				}
+			}
+		}
+
+		if m.Index < 0 {
+			s.logger.Warn("Search match not found in fortune files", zap.String("fortune", m.Fortune))
+			continue
+		}
+		db := dbs[d]
+		m.ID = fortuneID(db, m.Fortune)
+		m.SourceFile = db.Name
+		results = append(results, attributed{ranked: ranked{match: m, length: length}, db: db})
+	}
+	return results
+}
+
+// fortunesOf returns the fortunes of each of dbs, keyed by path, in index
+// order. They are taken from the search index when it has been built, so
+// only the files it doesn't cover yet are read.
+func (s *FortuneService) fortunesOf(ctx context.Context, dbs []database) (map[string][]string, error) {
+	wanted := make(map[string]bool, len(dbs))
+	for _, db := range dbs {
+		wanted[db.Path] = true
+	}
+
+	fortunes := make(map[string][]string, len(dbs))
+	if snap := s.inProcess.index.built(); snap != nil {
+		for _, doc := range snap.docs {
+			if wanted[doc.db.Path] {
+				fortunes[doc.db.Path] = append(fortunes[doc.db.Path], doc.text)
+			}
+		}
+	}
+
+	for _, db := range dbs {
+		if _, ok := fortunes[db.Path]; ok {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, contextError(err)
+		}
+		file, err := fortunefile.Open(db.Path)
+		if err != nil {
+			s.logger.Error("Failed to open fortune file", zap.Error(err), zap.String("file", db.Path))
+			return nil, fmt.Errorf("could not open fortune file: %w", err)
+		}
+		texts, err := file.All()
+		if err != nil {
+			s.logger.Error("Failed to read fortune file", zap.Error(err), zap.String("file", db.Path))
+			return nil, fmt.Errorf("could not read fortune file: %w", err)
+		}
+		fortunes[db.Path] = texts
+	}
+	return fortunes, nil
+}
+
+// resolveFiles validates the requested files and percentages and replaces
+// them with the full paths of the databases they resolved to. With no files
+// named, every database in the configured directories is passed instead, so
+// the binary never falls back to its compiled-in search path. The offensive
+// setting is settled by the catalog, which is why -a and -o are dropped.
+func (s *FortuneService) resolveFiles(opts FortuneOptions) (FortuneOptions, error) {
+	dbs, err := s.databases(opts)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.All = false
+	opts.Offensive = ""
+
+	paths := make([]string, len(dbs))
+	for i, db := range dbs {
+		paths[i] = db.Path
+	}
+	opts.Files = paths
+	return opts, nil
+}
+
+// databases returns the databases opts selects.
+func (s *FortuneService) databases(opts FortuneOptions) ([]database, error) {
+	dbs, err := selectDatabases(s.catalog, opts)
+	if err != nil && errors.Is(err, ErrBackendUnavailable) {
+		s.logger.Error("Failed to read fortune directories", zap.Error(err), zap.Strings("directories", s.catalog.Paths()))
+	}
+	return dbs, err
+}
+
+// commandError classifies a failed fortune invocation: aborted by ctx, binary
+// missing or not executable, or a failure of the command itself.
+func (s *FortuneService) commandError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return contextError(ctxErr)
+	}
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+	return fmt.Errorf("fortune command failed: %w", err)
+}
+
+// command prepares a fortune invocation that is killed when ctx is done.
+func (s *FortuneService) command(ctx context.Context, args []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, s.fortunePath, args...)
+	// Don't wait forever on output pipes held open by a killed process.
+	cmd.WaitDelay = time.Second
+	return cmd
 }
 
 func (s *FortuneService) buildArgs(opts FortuneOptions) []string {
 	var args []string
 
-	if opts.All {
+	switch {
+	case opts.Offensive == OffensiveOnly:
+		args = append(args, "-o")
+	case opts.Offensive == OffensiveInclude, opts.All && opts.Offensive == "":
 		args = append(args, "-a")
 	}
 	if opts.ShowCookie {
@@ -182,9 +582,54 @@ func (s *FortuneService) buildArgs(opts FortuneOptions) []string {
 	return args
 }
 
-// This function is now corrected to use the proper separator.
-func (s *FortuneService) parseSearchResults(output string) []FortuneResponse {
-	var matches []FortuneResponse
+// parseCookie separates the "(file)\n%\n" header that `fortune -c` prints
+// ahead of the fortune. cookie is empty if there is no header.
+func parseCookie(output string) (cookie, fortune string) {
+	if strings.HasPrefix(output, "(") {
+		if header, rest, ok := strings.Cut(output, "\n%\n"); ok && strings.HasSuffix(header, ")") {
+			return header[1 : len(header)-1], strings.TrimSpace(rest)
+		}
+	}
+	return "", strings.TrimSpace(output)
+}
+
+// cookieDatabase maps the file named by `fortune -c` back to a database.
+// Depending on its version fortune prints the full path or the base name.
+func (s *FortuneService) cookieDatabase(cookie string) (database, bool) {
+	if cookie == "" {
+		return database{}, false
+	}
+	dbs, err := s.catalog.Databases(OffensiveInclude)
+	if err != nil {
+		return database{}, false
+	}
+	for _, db := range dbs {
+		if db.Path == cookie {
+			return db, true
+		}
+	}
+	for _, db := range dbs {
+		if db.Name == filepath.Base(cookie) {
+			return db, true
+		}
+	}
+	return database{}, false
+}
+
+// exitStderr returns what a failed command wrote to stderr, if Output
+// captured it.
+func exitStderr(err error) string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return string(exitErr.Stderr)
+	}
+	return ""
+}
+
+// parseSearchResults splits the stdout of `fortune -m` into matches. Only the
+// text is filled in; see attribute.
+func (s *FortuneService) parseSearchResults(output string) []SearchMatch {
+	var matches []SearchMatch
 
 	// The `fortune -m` command separates matches with a '%' on its own line.
 	fortunes := strings.Split(output, "\n%\n")
@@ -192,8 +637,8 @@ func (s *FortuneService) parseSearchResults(output string) []FortuneResponse {
 	for _, fortune := range fortunes {
 		fortune = strings.TrimSpace(fortune)
 		if fortune != "" {
-			matches = append(matches, FortuneResponse{
-				Fortune: fortune,
+			matches = append(matches, SearchMatch{
+				FortuneResponse: FortuneResponse{Fortune: fortune},
 			})
 		}
 	}
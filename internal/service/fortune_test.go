@@ -1,14 +1,21 @@
 package service
 
 import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
 func TestBuildArgs(t *testing.T) {
-	s := NewFortuneService("", zap.NewNop())
+	s := NewFortuneService("", nil, nil, zap.NewNop())
 
 	testCases := []struct {
 		name     string
@@ -38,6 +45,21 @@ func TestBuildArgs(t *testing.T) {
 			},
 			expected: []string{"50%", "file1", "25%", "file2", "file3"},
 		},
+		{
+			name:     "Offensive only",
+			opts:     FortuneOptions{Offensive: OffensiveOnly},
+			expected: []string{"-o"},
+		},
+		{
+			name:     "Offensive include",
+			opts:     FortuneOptions{Offensive: OffensiveInclude},
+			expected: []string{"-a"},
+		},
+		{
+			name:     "Offensive exclude overrides all",
+			opts:     FortuneOptions{All: true, Offensive: OffensiveExclude},
+			expected: []string{},
+		},
 		{
 			name: "All options",
 			opts: FortuneOptions{
@@ -65,7 +87,7 @@ func TestBuildArgs(t *testing.T) {
 }
 
 func TestParseSearchResults(t *testing.T) {
-	s := NewFortuneService("", zap.NewNop())
+	s := NewFortuneService("", nil, nil, zap.NewNop())
 
 	testCases := []struct {
 		name           string
@@ -114,3 +136,323 @@ func TestParseSearchResults(t *testing.T) {
 		})
 	}
 }
+
+func TestParseCookie(t *testing.T) {
+	testCases := []struct {
+		name            string
+		output          string
+		expectedCookie  string
+		expectedFortune string
+	}{
+		{
+			name:            "With cookie",
+			output:          "(/usr/share/games/fortunes/wisdom)\n%\nKnow thyself.\n",
+			expectedCookie:  "/usr/share/games/fortunes/wisdom",
+			expectedFortune: "Know thyself.",
+		},
+		{
+			name:            "Without cookie",
+			output:          "Know thyself.\n",
+			expectedFortune: "Know thyself.",
+		},
+		{
+			name:            "Parenthesised fortune",
+			output:          "(An aside.)\n",
+			expectedFortune: "(An aside.)",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cookie, fortune := parseCookie(tc.output)
+			assert.Equal(t, tc.expectedCookie, cookie)
+			assert.Equal(t, tc.expectedFortune, fortune)
+		})
+	}
+}
+
+func TestGetFortune_ID(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	// A stand-in for `fortune -c` that always picks the last file it is given.
+	script := filepath.Join(t.TempDir(), "fortune")
+	require.NoError(t, os.WriteFile(script, []byte(`#!/bin/sh
+for file; do :; done
+printf '(%s)\n%%\nE = mc^2\n' "$file"
+`), 0o755))
+	s := NewFortuneService(script, newTestCatalog(newTestCorpus(t), false), nil, zap.NewNop())
+	ctx := context.Background()
+
+	resp, err := s.GetFortune(ctx, FortuneOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "E = mc^2", resp.Fortune)
+	assert.Empty(t, resp.SourceFile)
+	assert.Equal(t, fortuneID(database{Name: "science"}, "E = mc^2"), resp.ID)
+
+	resp, err = s.GetFortune(ctx, FortuneOptions{ShowCookie: true})
+	require.NoError(t, err)
+	assert.Equal(t, "science", resp.SourceFile)
+
+	got, err := s.GetFortuneByID(ctx, resp.ID)
+	require.NoError(t, err)
+	assert.Equal(t, resp.Fortune, got.Fortune)
+}
+
+func TestGetFortune_KilledOnDeadline(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	// A stand-in for a fortune binary that hangs.
+	script := filepath.Join(t.TempDir(), "fortune")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nexec sleep 10\n"), 0o755))
+	s := NewFortuneService(script, newTestCatalog(newTestCorpus(t), false), nil, zap.NewNop())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := s.GetFortune(ctx, FortuneOptions{})
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, err, ErrBackendTimeout)
+	assert.Less(t, time.Since(start), 5*time.Second, "process should be killed at the deadline")
+}
+
+// writeSearchScript writes a stand-in for `fortune -m` that finds one
+// fortune in animals and one in science, whatever the pattern, unless the
+// pattern is "[". Like fortune it prints a header for every file to stderr
+// and exits with status 1 after finding something. Every run is logged to
+// the returned file.
+func writeSearchScript(t *testing.T) (script, runs string) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	script = filepath.Join(t.TempDir(), "fortune")
+	require.NoError(t, os.WriteFile(script, []byte(`#!/bin/sh
+echo "$@" >> "$0.runs"
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-m) pattern=$2; shift 2 ;;
+	-*) shift ;;
+	*) break ;;
+	esac
+done
+if [ "$pattern" = "[" ]; then
+	echo 'fortune: bad regular expression' >&2
+	exit 2
+fi
+if [ "$pattern" = "edited" ]; then
+	printf 'This fortune has since been edited.\n%%\nEntropy always increases.\n%%\n'
+	exit 1
+fi
+for file; do
+	printf '(%s)\n%%\n' "$file" >&2
+	case "$file" in
+	*/animals) printf 'The cat sat on the mat.\n%%\n' ;;
+	*/science) printf 'Entropy always increases.\n%%\n' ;;
+	esac
+done
+exit 1
+`), 0o755))
+	return script, script + ".runs"
+}
+
+func TestSearchFortunes_Exec(t *testing.T) {
+	script, runs := writeSearchScript(t)
+	catalog := newTestCatalog(newTestCorpus(t), false)
+	index := NewIndex(catalog, zap.NewNop())
+	require.NoError(t, index.Build(context.Background()))
+
+	for name, index := range map[string]*Index{"Built index": index, "Without index": nil} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, os.RemoveAll(runs))
+			s := NewFortuneService(script, catalog, index, zap.NewNop())
+
+			resp, err := s.SearchFortunes(context.Background(), "a[t]", FortuneOptions{}, SearchPage{})
+			require.NoError(t, err)
+			require.Equal(t, 2, resp.Count)
+
+			log, err := os.ReadFile(runs)
+			require.NoError(t, err)
+			assert.Equal(t, 1, strings.Count(string(log), "\n"), "fortune runs once for all the files")
+
+			match := resp.Matches[0]
+			assert.Equal(t, "The cat sat on the mat.", match.Fortune)
+			assert.Equal(t, "animals", match.SourceFile)
+			assert.Equal(t, 0, match.Index)
+			assert.Equal(t, []Span{{Start: 5, End: 7}, {Start: 9, End: 11}, {Start: 20, End: 22}}, match.Highlights)
+			assert.Equal(t, fortuneID(database{Name: "animals"}, match.Fortune), match.ID)
+
+			// The science file is shuffled, so its order comes from the native backend.
+			native, err := NewNativeService(catalog, nil, zap.NewNop()).SearchFortunes(context.Background(), "Entropy", FortuneOptions{}, SearchPage{})
+			require.NoError(t, err)
+			match = resp.Matches[1]
+			assert.Equal(t, "science", match.SourceFile)
+			assert.Equal(t, native.Matches[0].Index, match.Index)
+
+			var streamed []SearchMatch
+			err = s.StreamSearch(context.Background(), "a[t]", FortuneOptions{}, SearchPage{}, func(m SearchMatch) error {
+				streamed = append(streamed, m)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, resp.Matches, streamed)
+
+			resp, err = s.SearchFortunes(context.Background(), "a[t]", FortuneOptions{MaxLength: 23}, SearchPage{})
+			require.NoError(t, err)
+			assert.Equal(t, 1, resp.Count, "size limits apply to what the binary finds")
+		})
+	}
+}
+
+func TestSearchFortunes_ExecPlainPattern(t *testing.T) {
+	catalog := newTestCatalog(newTestCorpus(t), false)
+	index := NewIndex(catalog, zap.NewNop())
+	require.NoError(t, index.Build(context.Background()))
+	// No binary: only the index can answer.
+	s := NewFortuneService(filepath.Join(t.TempDir(), "fortune"), catalog, index, zap.NewNop())
+
+	expected, err := NewNativeService(catalog, nil, zap.NewNop()).SearchFortunes(context.Background(), "at", FortuneOptions{}, SearchPage{})
+	require.NoError(t, err)
+	resp, err := s.SearchFortunes(context.Background(), "at", FortuneOptions{}, SearchPage{})
+	require.NoError(t, err)
+	assert.Equal(t, expected, resp)
+
+	_, err = s.SearchFortunes(context.Background(), "a[t]", FortuneOptions{}, SearchPage{})
+	assert.ErrorIs(t, err, ErrBackendUnavailable, "regular expressions still go to fortune")
+}
+
+func TestSearchFortunes_ExecUnknownMatch(t *testing.T) {
+	script, _ := writeSearchScript(t)
+	s := NewFortuneService(script, newTestCatalog(newTestCorpus(t), false), nil, zap.NewNop())
+
+	resp, err := s.SearchFortunes(context.Background(), "edited", FortuneOptions{}, SearchPage{})
+	require.NoError(t, err)
+	require.Equal(t, 1, resp.Count, "a match in none of the files has no ID to give")
+	assert.Equal(t, "Entropy always increases.", resp.Matches[0].Fortune)
+	assert.Equal(t, "science", resp.Matches[0].SourceFile)
+}
+
+func TestSearchFortunes_ExecPatternSyntax(t *testing.T) {
+	script, _ := writeSearchScript(t)
+	s := NewFortuneService(script, newTestCatalog(newTestCorpus(t), false), nil, zap.NewNop())
+
+	// Valid for fortune, though not for Go: found, just not highlighted.
+	resp, err := s.SearchFortunes(context.Background(), `\<cat\>`, FortuneOptions{}, SearchPage{})
+	require.NoError(t, err)
+	require.Equal(t, 2, resp.Count)
+	assert.Equal(t, "animals", resp.Matches[0].SourceFile)
+	assert.Empty(t, resp.Matches[0].Highlights)
+
+	_, err = s.SearchFortunes(context.Background(), "[", FortuneOptions{}, SearchPage{})
+	assert.ErrorIs(t, err, ErrBadPattern)
+}
+
+func TestSearchFortunes_CommandFailure(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	// Status 1 with nothing on stdout is a real failure.
+	script := filepath.Join(t.TempDir(), "fortune")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho 'No fortunes found' >&2\nexit 1\n"), 0o755))
+	s := NewFortuneService(script, newTestCatalog(newTestCorpus(t), false), nil, zap.NewNop())
+
+	_, err := s.SearchFortunes(context.Background(), "cat", FortuneOptions{}, SearchPage{})
+	assert.Error(t, err)
+}
+
+func TestGetFortune_MissingBinary(t *testing.T) {
+	s := NewFortuneService(filepath.Join(t.TempDir(), "no-such-fortune"), newTestCatalog(newTestCorpus(t), false), nil, zap.NewNop())
+
+	_, err := s.GetFortune(context.Background(), FortuneOptions{})
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+}
+
+func TestGetFortune_SeededInProcess(t *testing.T) {
+	// Seeded requests never reach the binary, which doesn't exist here.
+	catalog := newTestCatalog(newTestCorpus(t), false)
+	s := NewFortuneService(filepath.Join(t.TempDir(), "no-such-fortune"), catalog, nil, zap.NewNop())
+
+	resp, err := s.GetFortune(context.Background(), FortuneOptions{Seed: "replay"})
+	require.NoError(t, err)
+
+	expected, err := NewNativeService(catalog, nil, zap.NewNop()).GetFortune(context.Background(), FortuneOptions{Seed: "replay"})
+	require.NoError(t, err)
+	assert.Equal(t, expected, resp)
+}
+
+func TestGetFortune_DenylistInProcess(t *testing.T) {
+	// The binary can't leave out retired fortunes, and doesn't exist here.
+	d, err := NewDenylist(nil, []string{"."})
+	require.NoError(t, err)
+	catalog := NewCatalog([]Directory{{Path: newTestCorpus(t), OffensiveDir: testOffensiveDir}}, false, d)
+	s := NewFortuneService(filepath.Join(t.TempDir(), "no-such-fortune"), catalog, nil, zap.NewNop())
+
+	_, err = s.GetFortune(context.Background(), FortuneOptions{})
+	assert.ErrorIs(t, err, ErrNoMatch, "every fortune is retired")
+}
+
+func TestGetFortune_MatchInProcess(t *testing.T) {
+	s := NewFortuneService(filepath.Join(t.TempDir(), "no-such-fortune"), newTestCatalog(newTestCorpus(t), false), nil, zap.NewNop())
+
+	resp, err := s.GetFortune(context.Background(), FortuneOptions{Match: "^E = "})
+	require.NoError(t, err)
+	assert.Equal(t, "E = mc^2", resp.Fortune)
+}
+
+func TestGetFortune_SizeLimitsInProcess(t *testing.T) {
+	s := NewFortuneService(filepath.Join(t.TempDir(), "no-such-fortune"), newTestCatalog(newTestCorpus(t), false), nil, zap.NewNop())
+
+	resp, err := s.GetFortune(context.Background(), FortuneOptions{MaxLength: 10})
+	require.NoError(t, err)
+	assert.Equal(t, "E = mc^2", resp.Fortune)
+}
+
+func TestResolveFiles_SafeMode(t *testing.T) {
+	dir := newTestCorpus(t)
+	s := NewFortuneService("", newTestCatalog(dir, true), nil, zap.NewNop())
+
+	opts, err := s.resolveFiles(FortuneOptions{All: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "animals"), filepath.Join(dir, "science")}, s.buildArgs(opts),
+		"safe mode must drop -a and the offensive files")
+
+	_, err = s.resolveFiles(FortuneOptions{Offensive: OffensiveOnly})
+	assert.ErrorIs(t, err, ErrOffensiveDisabled)
+}
+
+func TestResolveFiles_NoOffensiveFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "animals", []string{"The cat sat on the mat."}, 0)
+	s := NewFortuneService("", NewCatalog([]Directory{{Path: dir}}, false, nil), nil, zap.NewNop())
+
+	_, err := s.resolveFiles(FortuneOptions{Offensive: OffensiveOnly})
+	assert.ErrorIs(t, err, ErrNoMatch, "a readable directory without offensive files is no outage")
+	assert.NotErrorIs(t, err, ErrBackendUnavailable)
+}
+
+func TestResolveFiles(t *testing.T) {
+	dir := newTestCorpus(t)
+	s := NewFortuneService("", newTestCatalog(dir, false), nil, zap.NewNop())
+
+	opts, err := s.resolveFiles(FortuneOptions{Files: []string{"science", "animals"}, Percentages: []string{"50"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "science"), filepath.Join(dir, "animals")}, opts.Files)
+	assert.Equal(t, []string{"50%", filepath.Join(dir, "science"), filepath.Join(dir, "animals")}, s.buildArgs(opts))
+
+	// Without files, every configured database is passed explicitly.
+	opts, err = s.resolveFiles(FortuneOptions{Offensive: OffensiveOnly})
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "off", "rude")}, s.buildArgs(opts))
+
+	_, err = s.resolveFiles(FortuneOptions{Files: []string{"-o"}})
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, "-o", verr.Errors[0].Value)
+}
@@ -0,0 +1,130 @@
+package service
+
+import "unicode/utf8"
+
+// maxFuzzyDistance is the most edits a fuzzy term may be away from the
+// word it matches.
+const maxFuzzyDistance = 2
+
+// Edit distances a term may carry in a query: a number after ~ sets it, as
+// in einstien~1, and a bare ~ scales it with the length of the term.
+const (
+	fuzzUnset = -1
+	fuzzAuto  = -2
+)
+
+// fuzziness is the request-wide fuzzy setting.
+type fuzziness struct {
+	enabled bool
+	// max caps the edits per term; zero leaves it to autoDistance.
+	max int
+}
+
+// distanceFor returns how many edits term may be away from a match, given
+// the distance the query set for it.
+func (f fuzziness) distanceFor(term string, fuzz int) int {
+	switch fuzz {
+	case fuzzUnset:
+		if !f.enabled {
+			return 0
+		}
+		return autoDistance(term, f.max)
+	case fuzzAuto:
+		return autoDistance(term, 0)
+	}
+	return fuzz
+}
+
+// autoDistance allows more edits in longer terms, so that short words don't
+// match half the dictionary: none up to two letters, one up to five, and
+// two beyond, capped at max when it is positive.
+func autoDistance(term string, max int) int {
+	var d int
+	switch n := utf8.RuneCountInString(term); {
+	case n <= 2:
+		d = 0
+	case n <= 5:
+		d = 1
+	default:
+		d = 2
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	return d
+}
+
+// variants returns every indexed term within distance edits of term, with
+// its distance.
+func (snap *snapshot) variants(term string, distance int) map[string]int {
+	out := map[string]int{}
+	if distance <= 0 {
+		if _, ok := snap.postings[term]; ok {
+			out[term] = 0
+		}
+		return out
+	}
+
+	for candidate := range snap.postings {
+		if d := editDistance(term, candidate, distance); d <= distance {
+			out[candidate] = d
+		}
+	}
+	return out
+}
+
+// editDistance returns the optimal string alignment distance between a and
+// b: the insertions, deletions, substitutions and swaps of adjacent
+// characters needed to turn one into the other. It gives up once the
+// distance must exceed max, returning max+1.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if abs(len(ra)-len(rb)) > max {
+		return max + 1
+	}
+
+	// Three rows of the dynamic programming table: two back, one back and
+	// the current one.
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	// A row can only improve on the one before by way of a swap, which
+	// costs an edit, so once two rows are past max every later one is too.
+	prevBest := 0
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			best = min(best, cur[j])
+		}
+		if best > max && prevBest >= max {
+			return max + 1
+		}
+		prevBest = best
+		prev2, prev, cur = prev, cur, prev2
+	}
+
+	if prev[len(rb)] > max {
+		return max + 1
+	}
+	return prev[len(rb)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditDistance(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		max      int
+		expected int
+	}{
+		{a: "cat", b: "cat", max: 2, expected: 0},
+		{a: "einstien", b: "einstein", max: 2, expected: 1},
+		{a: "shakespear", b: "shakespeare", max: 2, expected: 1},
+		{a: "kitten", b: "sitting", max: 3, expected: 3},
+		{a: "kitten", b: "sitting", max: 2, expected: 3},
+		{a: "ca", b: "abc", max: 3, expected: 3},
+		{a: "", b: "abc", max: 3, expected: 3},
+		{a: "naïve", b: "naive", max: 1, expected: 1},
+		{a: "cat", b: "elephant", max: 2, expected: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.a+"/"+tc.b, func(t *testing.T) {
+			assert.Equal(t, tc.expected, editDistance(tc.a, tc.b, tc.max))
+			assert.Equal(t, tc.expected, editDistance(tc.b, tc.a, tc.max))
+		})
+	}
+}
+
+func TestFuzzinessDistanceFor(t *testing.T) {
+	testCases := []struct {
+		name     string
+		f        fuzziness
+		term     string
+		fuzz     int
+		expected int
+	}{
+		{name: "Off", f: fuzziness{}, term: "einstien", fuzz: fuzzUnset, expected: 0},
+		{name: "Short term", f: fuzziness{enabled: true}, term: "ox", fuzz: fuzzUnset, expected: 0},
+		{name: "Medium term", f: fuzziness{enabled: true}, term: "twian", fuzz: fuzzUnset, expected: 1},
+		{name: "Long term", f: fuzziness{enabled: true}, term: "einstien", fuzz: fuzzUnset, expected: 2},
+		{name: "Capped", f: fuzziness{enabled: true, max: 1}, term: "einstien", fuzz: fuzzUnset, expected: 1},
+		{name: "Cap above scale", f: fuzziness{enabled: true, max: 2}, term: "twian", fuzz: fuzzUnset, expected: 1},
+		{name: "Bare tilde", f: fuzziness{}, term: "einstien", fuzz: fuzzAuto, expected: 2},
+		{name: "Explicit", f: fuzziness{}, term: "cat", fuzz: 2, expected: 2},
+		{name: "Explicit exact", f: fuzziness{enabled: true}, term: "einstien", fuzz: 0, expected: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.f.distanceFor(tc.term, tc.fuzz))
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"fortune-api/internal/fortunefile"
+
+	"go.uber.org/zap"
+)
+
+// idHashLen is the number of hex digits of the content hash kept in an ID.
+const idHashLen = 16
+
+// fortuneID identifies a fortune by the database it lives in and its text.
+// It depends on nothing but the corpus, so it is the same on every instance
+// and across restarts, and survives the file being reindexed or reordered.
+func fortuneID(db database, text string) string {
+	h := sha256.New()
+	h.Write([]byte(db.Name))
+	if db.Offensive {
+		h.Write([]byte{0, 'o'})
+	} else {
+		h.Write([]byte{0, 'r'})
+	}
+	h.Write([]byte{0})
+	h.Write([]byte(strings.TrimSpace(text)))
+	return db.Name + "-" + hex.EncodeToString(h.Sum(nil))[:idHashLen]
+}
+
+// parseFortuneID splits an ID into its database name and hash, reporting
+// whether it is well formed.
+func parseFortuneID(id string) (name string, ok bool) {
+	i := strings.LastIndexByte(id, '-')
+	if i <= 0 || len(id)-i-1 != idHashLen {
+		return "", false
+	}
+	if _, err := hex.DecodeString(id[i+1:]); err != nil {
+		return "", false
+	}
+	name = id[:i]
+	if !validFileName(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// fortuneByID finds the fortune with the given ID. Only the databases
+// carrying the name in the ID are read. Offensive fortunes are found even if
+// not asked for, as the ID names them explicitly, but never in safe mode.
+// Fortunes on the catalog's denylist are not found either.
+func fortuneByID(ctx context.Context, catalog *Catalog, id string, logger *zap.Logger) (*FortuneResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(err)
+	}
+
+	name, ok := parseFortuneID(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrFortuneNotFound, id)
+	}
+
+	dbs, err := catalog.Databases(OffensiveInclude)
+	if err != nil {
+		logger.Error("Failed to read fortune directories", zap.Error(err), zap.Strings("directories", catalog.Paths()))
+		return nil, fmt.Errorf("%w: could not read fortune directory: %w", ErrBackendUnavailable, err)
+	}
+
+	for _, db := range dbs {
+		if db.Name != name {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, contextError(err)
+		}
+
+		file, err := fortunefile.Open(db.Path)
+		if err != nil {
+			logger.Error("Failed to open fortune file", zap.Error(err), zap.String("file", db.Path))
+			return nil, fmt.Errorf("could not open fortune file: %w", err)
+		}
+		fortunes, err := file.All()
+		if err != nil {
+			logger.Error("Failed to read fortune file", zap.Error(err), zap.String("file", db.Path))
+			return nil, fmt.Errorf("could not read fortune file: %w", err)
+		}
+
+		for _, text := range fortunes {
+			if fortuneID(db, text) == id {
+				if catalog.retired(id, text) {
+					break
+				}
+				return &FortuneResponse{
+					ID:         id,
+					Fortune:    strings.TrimSpace(text),
+					SourceFile: db.Name,
+				}, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrFortuneNotFound, id)
+}
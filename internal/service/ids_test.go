@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestFortuneID(t *testing.T) {
+	regular := database{Name: "rude", Path: "/a/rude"}
+	moved := database{Name: "rude", Path: "/b/rude"}
+	offensive := database{Name: "rude", Offensive: true}
+
+	id := fortuneID(regular, "A joke.")
+	assert.True(t, strings.HasPrefix(id, "rude-"))
+	assert.Len(t, id, len("rude-")+idHashLen)
+	assert.Equal(t, id, fortuneID(regular, "  A joke.\n"), "surrounding space is ignored")
+	assert.Equal(t, id, fortuneID(moved, "A joke."), "the directory is not part of the ID")
+	assert.NotEqual(t, id, fortuneID(offensive, "A joke."))
+	assert.NotEqual(t, id, fortuneID(regular, "Another joke."))
+}
+
+func TestParseFortuneID(t *testing.T) {
+	testCases := []struct {
+		id       string
+		expected string
+		ok       bool
+	}{
+		{id: "animals-0123456789abcdef", expected: "animals", ok: true},
+		{id: "star-trek-0123456789abcdef", expected: "star-trek", ok: true},
+		{id: "animals-0123456789abcdeg"},
+		{id: "animals-0123"},
+		{id: "-0123456789abcdef"},
+		{id: "..-0123456789abcdef"},
+		{id: "animals"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			name, ok := parseFortuneID(tc.id)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.expected, name)
+		})
+	}
+}
+
+func TestFortuneByID(t *testing.T) {
+	dir := newTestCorpus(t)
+	ctx := context.Background()
+	s := NewNativeService(newTestCatalog(dir, false), nil, zap.NewNop())
+
+	resp, err := s.GetFortune(ctx, FortuneOptions{Files: []string{"science"}})
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.ID)
+
+	got, err := s.GetFortuneByID(ctx, resp.ID)
+	require.NoError(t, err)
+	assert.Equal(t, resp.Fortune, got.Fortune)
+	assert.Equal(t, "science", got.SourceFile)
+
+	// A fresh catalog over the same corpus agrees on the ID.
+	again, err := fortuneByID(ctx, newTestCatalog(dir, false), resp.ID, zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, got, again)
+
+	rudeID := fortuneID(database{Name: "rude", Offensive: true}, "An offensive cat joke.")
+	got, err = s.GetFortuneByID(ctx, rudeID)
+	require.NoError(t, err)
+	assert.Equal(t, "An offensive cat joke.", got.Fortune)
+
+	_, err = fortuneByID(ctx, newTestCatalog(dir, true), rudeID, zap.NewNop())
+	assert.ErrorIs(t, err, ErrFortuneNotFound, "safe mode hides offensive fortunes")
+
+	for _, id := range []string{"science-0000000000000000", "missing-0123456789abcdef", "not an id"} {
+		_, err = s.GetFortuneByID(ctx, id)
+		assert.ErrorIs(t, err, ErrFortuneNotFound, id)
+	}
+}
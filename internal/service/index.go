@@ -0,0 +1,471 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"fortune-api/internal/fortunefile"
+
+	"go.uber.org/zap"
+)
+
+// IndexStats describes the current search index.
+type IndexStats struct {
+	Files       int       `json:"files"`
+	Documents   int       `json:"documents"`
+	Terms       int       `json:"terms"`
+	SizeBytes   int64     `json:"size_bytes"`
+	BuildMillis float64   `json:"build_ms"`
+	BuiltAt     time.Time `json:"built_at"`
+}
+
+// IndexStatser is implemented by services that keep a search index. The
+// health endpoint reports the stats when available.
+type IndexStatser interface {
+	IndexStats() (IndexStats, bool)
+}
+
+// Index is an in-memory inverted index over every fortune in a catalog,
+// used for word and phrase queries. It is replaced as a whole on rebuild, so
+// searches never see a half-built index.
+type Index struct {
+	catalog *Catalog
+	logger  *zap.Logger
+
+	current atomic.Pointer[snapshot]
+	buildMu sync.Mutex
+}
+
+// snapshot is one build of the index.
+type snapshot struct {
+	docs        []document
+	postings    map[string][]int32
+	paths       map[string]bool
+	fingerprint string
+	stats       IndexStats
+	// avgLen is the mean number of words in a fortune, for BM25.
+	avgLen float64
+}
+
+// document is one fortune as stored in the index. Text is kept as read, so
+// the length filters see what they would see on disk.
+type document struct {
+	db    database
+	index int
+	text  string
+}
+
+func NewIndex(catalog *Catalog, logger *zap.Logger) *Index {
+	return &Index{catalog: catalog, logger: logger}
+}
+
+// Stats returns the stats of the current build, and false if the index has
+// not been built yet.
+func (x *Index) Stats() (IndexStats, bool) {
+	if x == nil {
+		return IndexStats{}, false
+	}
+	snap := x.current.Load()
+	if snap == nil {
+		return IndexStats{}, false
+	}
+	return snap.stats, true
+}
+
+// Build reads every database in the catalog and swaps in a fresh index. On
+// failure the previous index stays in place.
+func (x *Index) Build(ctx context.Context) error {
+	x.buildMu.Lock()
+	defer x.buildMu.Unlock()
+
+	dbs, err := x.catalog.Databases(OffensiveInclude)
+	if err != nil {
+		return fmt.Errorf("%w: could not read fortune directory: %w", ErrBackendUnavailable, err)
+	}
+	fingerprint, err := fingerprintOf(dbs)
+	if err != nil {
+		return err
+	}
+
+	snap, err := buildSnapshot(ctx, dbs)
+	if err != nil {
+		return err
+	}
+	snap.fingerprint = fingerprint
+	x.current.Store(snap)
+
+	x.logger.Info("Search index built",
+		zap.Int("files", snap.stats.Files),
+		zap.Int("documents", snap.stats.Documents),
+		zap.Int("terms", snap.stats.Terms),
+		zap.Float64("build_ms", snap.stats.BuildMillis))
+	return nil
+}
+
+// Refresh rebuilds the index if any database was added, removed or changed
+// since the last build.
+func (x *Index) Refresh(ctx context.Context) error {
+	if snap := x.current.Load(); snap != nil {
+		dbs, err := x.catalog.Databases(OffensiveInclude)
+		if err != nil {
+			return fmt.Errorf("%w: could not read fortune directory: %w", ErrBackendUnavailable, err)
+		}
+		fingerprint, err := fingerprintOf(dbs)
+		if err != nil {
+			return err
+		}
+		if fingerprint == snap.fingerprint {
+			return nil
+		}
+	}
+	return x.Build(ctx)
+}
+
+// Watch refreshes the index every interval until ctx is done.
+func (x *Index) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := x.Refresh(ctx); err != nil && ctx.Err() == nil {
+				x.logger.Error("Failed to refresh search index", zap.Error(err))
+			}
+		}
+	}
+}
+
+// snapshotFor returns the index to search dbs with. Without a built index,
+// a throwaway one is built over dbs alone.
+func (x *Index) snapshotFor(ctx context.Context, dbs []database) (*snapshot, error) {
+	if snap := x.built(); snap != nil {
+		return snap, nil
+	}
+	return buildSnapshot(ctx, dbs)
+}
+
+// built returns the current build, or nil if the index has none yet.
+func (x *Index) built() *snapshot {
+	if x == nil {
+		return nil
+	}
+	return x.current.Load()
+}
+
+// buildSnapshot indexes every fortune in dbs.
+func buildSnapshot(ctx context.Context, dbs []database) (*snapshot, error) {
+	start := time.Now()
+	snap := &snapshot{postings: make(map[string][]int32), paths: make(map[string]bool, len(dbs))}
+	var words int
+
+	for _, db := range dbs {
+		if err := ctx.Err(); err != nil {
+			return nil, contextError(err)
+		}
+		snap.paths[db.Path] = true
+
+		file, err := fortunefile.Open(db.Path)
+		if err != nil {
+			return nil, fmt.Errorf("could not open fortune file: %w", err)
+		}
+		fortunes, err := file.All()
+		if err != nil {
+			return nil, fmt.Errorf("could not read fortune file: %w", err)
+		}
+
+		for i, text := range fortunes {
+			id := int32(len(snap.docs))
+			snap.docs = append(snap.docs, document{db: db, index: i, text: text})
+			snap.stats.SizeBytes += int64(len(text))
+
+			tokens := tokenize(text)
+			words += len(tokens)
+			for _, t := range tokens {
+				list := snap.postings[t.term]
+				if len(list) > 0 && list[len(list)-1] == id {
+					continue
+				}
+				if list == nil {
+					snap.stats.SizeBytes += int64(len(t.term))
+				}
+				snap.postings[t.term] = append(list, id)
+				snap.stats.SizeBytes += 4
+			}
+		}
+	}
+
+	if len(snap.docs) > 0 {
+		snap.avgLen = float64(words) / float64(len(snap.docs))
+	}
+	snap.stats.Files = len(dbs)
+	snap.stats.Documents = len(snap.docs)
+	snap.stats.Terms = len(snap.postings)
+	snap.stats.BuiltAt = time.Now().UTC()
+	snap.stats.BuildMillis = float64(time.Since(start).Microseconds()) / 1000
+	return snap, nil
+}
+
+// intersectAll intersects sorted posting lists, shortest first.
+func intersectAll(lists [][]int32) []int32 {
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+
+	result := lists[0]
+	for _, list := range lists[1:] {
+		result = intersect(result, list)
+		if len(result) == 0 {
+			return nil
+		}
+	}
+	return result
+}
+
+// intersect merges two sorted posting lists.
+func intersect(a, b []int32) []int32 {
+	var out []int32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// union merges two sorted posting lists.
+func union(a, b []int32) []int32 {
+	out := make([]int32, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			out = append(out, a[i])
+			i++
+		case a[i] > b[j]:
+			out = append(out, b[j])
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	out = append(out, a[i:]...)
+	return append(out, b[j:]...)
+}
+
+// fingerprintOf summarises the databases and their modification times, so a
+// change on disk can be noticed without reading any fortunes.
+func fingerprintOf(dbs []database) (string, error) {
+	var b strings.Builder
+	for _, db := range dbs {
+		for _, path := range []string{db.Path, db.Path + fortunefile.IndexSuffix} {
+			info, err := os.Stat(path)
+			if err != nil {
+				return "", fmt.Errorf("could not stat fortune file: %w", err)
+			}
+			fmt.Fprintf(&b, "%s\x00%t\x00%d\x00%d\n", path, db.Offensive, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return b.String(), nil
+}
+
+// queryIndex runs a search query over the databases opts selects and calls
+// fn with every match in file order, stopping at the first error fn returns.
+func queryIndex(ctx context.Context, index *Index, catalog *Catalog, query string, opts FortuneOptions, logger *zap.Logger, fn func(ranked) error) error {
+	q, err := parseQuery(query)
+	if err != nil {
+		return err
+	}
+
+	dbs, err := selectDatabases(catalog, opts)
+	if err != nil {
+		if errors.Is(err, ErrBackendUnavailable) {
+			logger.Error("Failed to read fortune directories", zap.Error(err), zap.Strings("directories", catalog.Paths()))
+		}
+		return err
+	}
+	allowed := make(map[string]bool, len(dbs))
+	for _, db := range dbs {
+		allowed[db.Path] = true
+	}
+
+	snap, err := index.snapshotFor(ctx, dbs)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Error("Failed to index fortune files", zap.Error(err))
+		}
+		return err
+	}
+
+	q.expand(snap, fuzziness{enabled: opts.Fuzzy, max: opts.FuzzyDistance})
+	ids, all := q.candidates(snap)
+	if all {
+		ids = make([]int32, len(snap.docs))
+		for i := range ids {
+			ids[i] = int32(i)
+		}
+	}
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return contextError(err)
+		}
+
+		doc := snap.docs[id]
+		if !allowed[doc.db.Path] || !lengthAllowed(doc.text, opts) || catalog.excluded(doc.db, doc.text, opts) {
+			continue
+		}
+		c := newCandidate(doc.db, doc.text)
+		m, ok := matchQuery(q, snap, c)
+		if !ok {
+			continue
+		}
+		err := fn(ranked{
+			match: SearchMatch{
+				FortuneResponse: FortuneResponse{
+					ID:         fortuneID(doc.db, c.text),
+					Fortune:    c.text,
+					SourceFile: doc.db.Name,
+				},
+				Index:      doc.index,
+				Highlights: m.spans,
+				Fuzzy:      m.fuzzy,
+				Score:      m.score,
+			},
+			distance: m.distance,
+			length:   len(doc.text),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// plainPattern reports whether a search pattern is literal text with at
+// least one word in it, which the index can serve without a regex scan.
+// Ignoring case, a letter with more than two case forms, such as σ, ς and
+// Σ, is not plain: the regex folds them all together, where the index's
+// lowercasing keeps ς apart.
+func plainPattern(pattern string, ignoreCase bool) bool {
+	if regexp.QuoteMeta(pattern) != pattern || len(tokenize(pattern)) == 0 {
+		return false
+	}
+	if ignoreCase {
+		for _, r := range pattern {
+			if unicode.SimpleFold(unicode.SimpleFold(r)) != r {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// searchIndexed serves a plain pattern search from the built index: the
+// candidates are the fortunes with, for every word of the pattern, a word
+// containing it, and the pattern is then matched against each of them, so
+// the matches and their order are those of a file scan. It reports false,
+// having called nothing, when the pattern isn't plain or the index hasn't
+// been built over every database opts selects, and the files must be
+// scanned instead.
+func searchIndexed(ctx context.Context, index *Index, catalog *Catalog, pattern string, opts FortuneOptions, logger *zap.Logger, fn func(ranked) error) (bool, error) {
+	snap := index.built()
+	if snap == nil || !plainPattern(pattern, opts.IgnoreCase) {
+		return false, nil
+	}
+
+	dbs, err := selectDatabases(catalog, opts)
+	if err != nil {
+		if errors.Is(err, ErrBackendUnavailable) {
+			logger.Error("Failed to read fortune directories", zap.Error(err), zap.Strings("directories", catalog.Paths()))
+		}
+		return true, err
+	}
+	order := make(map[string]int, len(dbs))
+	for i, db := range dbs {
+		if !snap.paths[db.Path] {
+			return false, nil
+		}
+		order[db.Path] = i
+	}
+
+	re, err := compilePattern(pattern, opts.IgnoreCase)
+	if err != nil {
+		return true, fmt.Errorf("%w: %v", ErrBadPattern, err)
+	}
+
+	// hits counts, per document, the words of the pattern seen so far; a
+	// document only moves on to k+1 if it had all of the first k.
+	words := terms(tokenize(pattern))
+	hits := make([]int, len(snap.docs))
+	for k, word := range words {
+		for term, postings := range snap.postings {
+			if !strings.Contains(term, word) {
+				continue
+			}
+			for _, id := range postings {
+				if hits[id] == k {
+					hits[id] = k + 1
+				}
+			}
+		}
+	}
+
+	byDB := make([][]int32, len(dbs))
+	for id, n := range hits {
+		if n < len(words) {
+			continue
+		}
+		if i, ok := order[snap.docs[id].db.Path]; ok {
+			byDB[i] = append(byDB[i], int32(id))
+		}
+	}
+
+	for _, ids := range byDB {
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return true, contextError(err)
+			}
+
+			doc := snap.docs[id]
+			if !lengthAllowed(doc.text, opts) || !re.MatchString(doc.text) || catalog.excluded(doc.db, doc.text, opts) {
+				continue
+			}
+			trimmed := strings.TrimSpace(doc.text)
+			err := fn(ranked{
+				match: SearchMatch{
+					FortuneResponse: FortuneResponse{
+						ID:         fortuneID(doc.db, trimmed),
+						Fortune:    trimmed,
+						SourceFile: doc.db.Name,
+					},
+					Index:      doc.index,
+					Highlights: highlights(re, trimmed),
+				},
+				length: len(doc.text),
+			})
+			if err != nil {
+				return true, err
+			}
+		}
+	}
+	return true, nil
+}
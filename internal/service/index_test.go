@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestIndex(t *testing.T, catalog *Catalog) *Index {
+	t.Helper()
+	index := NewIndex(catalog, zap.NewNop())
+	require.NoError(t, index.Build(context.Background()))
+	return index
+}
+
+func TestIndexStats(t *testing.T) {
+	catalog := newTestCatalog(newTestCorpus(t), false)
+	index := NewIndex(catalog, zap.NewNop())
+
+	_, built := index.Stats()
+	assert.False(t, built)
+
+	require.NoError(t, index.Build(context.Background()))
+	stats, built := index.Stats()
+	require.True(t, built)
+	assert.Equal(t, 3, stats.Files)
+	assert.Equal(t, 6, stats.Documents)
+	assert.Positive(t, stats.Terms)
+	assert.Positive(t, stats.SizeBytes)
+	assert.False(t, stats.BuiltAt.IsZero())
+
+	var nilIndex *Index
+	_, built = nilIndex.Stats()
+	assert.False(t, built)
+}
+
+func TestQueryIndex(t *testing.T) {
+	catalog := newTestCatalog(newTestCorpus(t), false)
+	ctx := context.Background()
+
+	for name, index := range map[string]*Index{"Built": newTestIndex(t, catalog), "Without index": nil} {
+		t.Run(name, func(t *testing.T) {
+			s := NewNativeService(catalog, index, zap.NewNop())
+
+			resp, err := s.QueryFortunes(ctx, "cat", FortuneOptions{}, SearchPage{})
+			require.NoError(t, err)
+			require.Equal(t, 1, resp.Count, "words match whole words only")
+			match := resp.Matches[0]
+			assert.Equal(t, "The cat sat on the mat.", match.Fortune)
+			assert.Equal(t, "animals", match.SourceFile)
+			assert.Equal(t, 0, match.Index)
+			assert.Equal(t, []Span{{Start: 4, End: 7}}, match.Highlights)
+			assert.Equal(t, fortuneID(database{Name: "animals"}, match.Fortune), match.ID)
+
+			resp, err = s.QueryFortunes(ctx, "cat", FortuneOptions{Offensive: OffensiveInclude}, SearchPage{})
+			require.NoError(t, err)
+			assert.Equal(t, 2, resp.Count)
+
+			resp, err = s.QueryFortunes(ctx, `"nine lives" cats`, FortuneOptions{}, SearchPage{})
+			require.NoError(t, err)
+			assert.Equal(t, 1, resp.Count)
+
+			resp, err = s.QueryFortunes(ctx, `"nine lives"`, FortuneOptions{Short: true}, SearchPage{})
+			require.NoError(t, err)
+			assert.Equal(t, 0, resp.Count, "length filters apply")
+
+			resp, err = s.QueryFortunes(ctx, "always", FortuneOptions{Files: []string{"animals"}}, SearchPage{})
+			require.NoError(t, err)
+			assert.Equal(t, 0, resp.Count, "only the requested files are searched")
+
+			_, err = s.QueryFortunes(ctx, "!!", FortuneOptions{}, SearchPage{})
+			assert.ErrorIs(t, err, ErrBadPattern)
+		})
+	}
+}
+
+func TestQueryIndexLanguage(t *testing.T) {
+	dir := newTestCorpus(t)
+	writeTestDatabase(t, dir, "quotes", []string{
+		"Be yourself; everyone else is already taken.\n\t\t-- Oscar Wilde",
+		"The cat is a law unto itself.\n\t\t-- Anonymous",
+	}, 0)
+	catalog := newTestCatalog(dir, false)
+	s := NewNativeService(catalog, newTestIndex(t, catalog), zap.NewNop())
+	ctx := context.Background()
+
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "cat OR dog", expected: []string{"animals", "animals", "quotes"}},
+		{query: "cat NOT file:animals", expected: []string{"quotes"}},
+		{query: "(cat OR entropy) -law", expected: []string{"animals", "science"}},
+		{query: "NOT (cat OR dog OR entropy OR cats)", expected: []string{"quotes", "science"}},
+		{query: "file:sci* len:<10", expected: []string{"science"}},
+		{query: "author:wilde", expected: []string{"quotes"}},
+		{query: "author:cat", expected: []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			resp, err := s.QueryFortunes(ctx, tc.query, FortuneOptions{}, SearchPage{Sort: SearchByFile})
+			require.NoError(t, err)
+			files := []string{}
+			for _, m := range resp.Matches {
+				files = append(files, m.SourceFile)
+			}
+			assert.Equal(t, tc.expected, files)
+		})
+	}
+
+	_, err := s.QueryFortunes(ctx, "cat AND (dog", FortuneOptions{}, SearchPage{})
+	var qerr *QuerySyntaxError
+	require.ErrorAs(t, err, &qerr)
+	assert.Equal(t, 8, qerr.Position)
+}
+
+func TestQueryIndexFuzzy(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "quotes", []string{
+		"All the world's a stage.\n\t\t-- William Shakespeare",
+		"Shakespear never spelled it this way.",
+		"Imagination is more important than knowledge.\n\t\t-- Albert Einstein",
+	}, 0)
+	catalog := newTestCatalog(dir, false)
+	s := NewNativeService(catalog, newTestIndex(t, catalog), zap.NewNop())
+	ctx := context.Background()
+
+	resp, err := s.QueryFortunes(ctx, "einstien", FortuneOptions{}, SearchPage{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, resp.Count)
+
+	resp, err = s.QueryFortunes(ctx, "einstien", FortuneOptions{Fuzzy: true}, SearchPage{})
+	require.NoError(t, err)
+	require.Equal(t, 1, resp.Count)
+	assert.Equal(t, 2, resp.Matches[0].Index)
+	assert.Equal(t, []FuzzyMatch{{Term: "einstien", Matched: "einstein", Distance: 1}}, resp.Matches[0].Fuzzy)
+
+	t.Run("Ranked by closeness", func(t *testing.T) {
+		resp, err := s.QueryFortunes(ctx, "shakespear", FortuneOptions{Fuzzy: true}, SearchPage{})
+		require.NoError(t, err)
+		require.Equal(t, 2, resp.Count)
+		assert.Equal(t, 1, resp.Matches[0].Index, "the exact match comes first")
+		assert.Empty(t, resp.Matches[0].Fuzzy)
+		assert.Equal(t, 0, resp.Matches[1].Index)
+		assert.Equal(t, []FuzzyMatch{{Term: "shakespear", Matched: "shakespeare", Distance: 1}}, resp.Matches[1].Fuzzy)
+	})
+
+	t.Run("Distance caps the edits", func(t *testing.T) {
+		resp, err := s.QueryFortunes(ctx, "imagniatoin", FortuneOptions{Fuzzy: true, FuzzyDistance: 1}, SearchPage{})
+		require.NoError(t, err)
+		assert.Equal(t, 0, resp.Count)
+
+		resp, err = s.QueryFortunes(ctx, "imagniatoin", FortuneOptions{Fuzzy: true}, SearchPage{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, resp.Count)
+	})
+
+	t.Run("Per-term distance", func(t *testing.T) {
+		resp, err := s.QueryFortunes(ctx, "author:shakespere~1", FortuneOptions{}, SearchPage{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, resp.Count)
+
+		resp, err = s.QueryFortunes(ctx, "shakespear~0", FortuneOptions{Fuzzy: true}, SearchPage{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, resp.Count, "~0 keeps a term exact")
+	})
+}
+
+func TestQueryIndexRanking(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "pets", []string{
+		"A dog, a bird and a cat went to the market to buy some bread and milk.",
+		"Cat.",
+		"The cat chased another cat.",
+		"Dogs bark.",
+	}, 0)
+	catalog := newTestCatalog(dir, false)
+	s := NewNativeService(catalog, newTestIndex(t, catalog), zap.NewNop())
+	ctx := context.Background()
+
+	resp, err := s.QueryFortunes(ctx, "cat", FortuneOptions{}, SearchPage{})
+	require.NoError(t, err)
+	require.Equal(t, 3, resp.Total)
+	assert.Equal(t, []int{1, 2, 0}, matchIndexes(resp), "short and repeated matches rank first")
+	assert.Greater(t, resp.Matches[0].Score, resp.Matches[2].Score)
+
+	t.Run("Paged with a cursor", func(t *testing.T) {
+		var seen []int
+		page := SearchPage{Limit: 2}
+		for {
+			resp, err := s.QueryFortunes(ctx, "cat", FortuneOptions{}, page)
+			require.NoError(t, err)
+			assert.Equal(t, 3, resp.Total)
+			seen = append(seen, matchIndexes(resp)...)
+			if resp.NextCursor == "" {
+				break
+			}
+			page.Cursor = resp.NextCursor
+		}
+		assert.Equal(t, []int{1, 2, 0}, seen)
+	})
+
+	t.Run("File order", func(t *testing.T) {
+		resp, err := s.QueryFortunes(ctx, "cat", FortuneOptions{}, SearchPage{Sort: SearchByFile, Offset: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, matchIndexes(resp))
+	})
+
+	t.Run("Facets", func(t *testing.T) {
+		resp, err := s.QueryFortunes(ctx, "cat", FortuneOptions{Length: 20}, SearchPage{Limit: 1, Facets: []string{FacetFile, FacetLength}})
+		require.NoError(t, err)
+		require.NotNil(t, resp.Facets)
+		assert.Equal(t, map[string]int{"pets": 3}, resp.Facets.File)
+		assert.Equal(t, map[string]int{LengthShort: 1, LengthMedium: 1, LengthLong: 1}, resp.Facets.Length)
+	})
+
+	t.Run("Streamed", func(t *testing.T) {
+		var streamed []int
+		err := s.StreamQuery(ctx, "cat", FortuneOptions{}, SearchPage{}, func(m SearchMatch) error {
+			streamed = append(streamed, m.Index)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 0}, streamed, "in the same order as a page")
+
+		err = s.StreamQuery(ctx, "cat AND (", FortuneOptions{}, SearchPage{}, nil)
+		var qerr *QuerySyntaxError
+		assert.ErrorAs(t, err, &qerr)
+	})
+}
+
+func TestQueryIndexSafeMode(t *testing.T) {
+	dir := newTestCorpus(t)
+	catalog := newTestCatalog(dir, true)
+	s := NewNativeService(catalog, newTestIndex(t, catalog), zap.NewNop())
+
+	resp, err := s.QueryFortunes(context.Background(), "offensive", FortuneOptions{All: true}, SearchPage{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, resp.Count)
+}
+
+func TestIndexRefresh(t *testing.T) {
+	dir := newTestCorpus(t)
+	catalog := newTestCatalog(dir, false)
+	index := newTestIndex(t, catalog)
+	s := NewNativeService(catalog, index, zap.NewNop())
+	ctx := context.Background()
+
+	first, _ := index.Stats()
+	require.NoError(t, index.Refresh(ctx))
+	unchanged, _ := index.Stats()
+	assert.Equal(t, first.BuiltAt, unchanged.BuiltAt, "nothing changed, so nothing is rebuilt")
+
+	writeTestDatabase(t, dir, "zoo", []string{"A zebra crossing."}, 0)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "zoo"), later, later))
+
+	resp, err := s.QueryFortunes(ctx, "zebra", FortuneOptions{}, SearchPage{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, resp.Count, "not indexed yet")
+
+	require.NoError(t, index.Refresh(ctx))
+	resp, err = s.QueryFortunes(ctx, "zebra", FortuneOptions{}, SearchPage{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.Count)
+
+	stats, _ := index.Stats()
+	assert.Equal(t, 4, stats.Files)
+}
+
+func TestIndexWatchStops(t *testing.T) {
+	index := NewIndex(newTestCatalog(newTestCorpus(t), false), zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		index.Watch(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, built := index.Stats()
+		return built
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Watch did not return after cancel")
+	}
+}
@@ -0,0 +1,386 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Ensure NativeService implements the interface.
+var (
+	_ FortuneServiceInterface = (*NativeService)(nil)
+	_ IndexStatser            = (*NativeService)(nil)
+	_ SearchStreamer          = (*NativeService)(nil)
+)
+
+// NativeService serves fortunes by reading the databases directly, without
+// the fortune binary.
+type NativeService struct {
+	catalog *Catalog
+	index   *Index
+	logger  *zap.Logger
+}
+
+// NewNativeService creates the native backend. index serves word queries;
+// it may be nil, in which case every query reads the files it covers.
+func NewNativeService(catalog *Catalog, index *Index, logger *zap.Logger) *NativeService {
+	return &NativeService{
+		catalog: catalog,
+		index:   index,
+		logger:  logger,
+	}
+}
+
+// GetFortune draws with opts.Seed, or with a fresh seed if none is given.
+// Either way the seed is returned, so the draw can be replayed. With
+// opts.Match, the draw is among the matching fortunes only.
+func (s *NativeService) GetFortune(ctx context.Context, opts FortuneOptions) (*FortuneResponse, error) {
+	re, err := filterPattern(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sources, err := openSources(ctx, s.catalog, opts, s.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like `fortune -m`, a pattern returns every match rather than one fortune.
+	if opts.Pattern != "" {
+		return s.matchAll(ctx, sources, opts)
+	}
+
+	fortunes, err := s.draw(ctx, sources, opts, re, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(fortunes) == 0 {
+		return nil, ErrNoMatch
+	}
+	return &fortunes[0], nil
+}
+
+// GetFortunes draws up to count distinct fortunes. Unlike GetFortune, a
+// pattern only narrows down the fortunes to draw from, just like a match.
+func (s *NativeService) GetFortunes(ctx context.Context, opts FortuneOptions, count int) (*FortunesResponse, error) {
+	re, err := filterPattern(opts)
+	if err != nil {
+		return nil, err
+	}
+	if re == nil && opts.Pattern != "" {
+		if re, err = compilePattern(opts.Pattern, opts.IgnoreCase); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadPattern, err)
+		}
+	}
+
+	sources, err := openSources(ctx, s.catalog, opts, s.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	fortunes, err := s.draw(ctx, sources, opts, re, count)
+	if err != nil {
+		return nil, err
+	}
+	if len(fortunes) == 0 {
+		return nil, ErrNoMatch
+	}
+	return &FortunesResponse{
+		Fortunes: fortunes,
+		Count:    len(fortunes),
+		Partial:  len(fortunes) < count,
+		Seed:     fortunes[0].Seed,
+	}, nil
+}
+
+// draw picks up to n distinct fortunes that pass the length filters and
+// aren't excluded. With re, it picks uniformly among every fortune re
+// matches, whatever file it is in. Otherwise it picks a file by weight,
+// then a fortune from it, repeatedly; files run dry are given no further
+// weight. Fewer than n fortunes are returned only once none are left.
+func (s *NativeService) draw(ctx context.Context, sources []source, opts FortuneOptions, re *regexp.Regexp, n int) ([]FortuneResponse, error) {
+	seed := opts.Seed
+	if seed == "" {
+		seed = newSeed()
+	}
+	rng := newRand(seed)
+
+	seen := make(map[string]bool)
+	var picked []FortuneResponse
+	add := func(src source, text string) {
+		text = strings.TrimSpace(text)
+		if seen[text] {
+			return
+		}
+		seen[text] = true
+
+		response := FortuneResponse{
+			ID:      fortuneID(src.db, text),
+			Fortune: text,
+			Seed:    seed,
+		}
+		if opts.ShowCookie {
+			response.SourceFile = src.db.Name
+		}
+		picked = append(picked, response)
+	}
+
+	if re != nil {
+		type match struct {
+			source int
+			text   string
+		}
+		var pool []match
+		for i, src := range sources {
+			if err := ctx.Err(); err != nil {
+				return nil, contextError(err)
+			}
+			fortunes, err := s.eligible(src, opts, re)
+			if err != nil {
+				return nil, err
+			}
+			for _, text := range fortunes {
+				pool = append(pool, match{source: i, text: text})
+			}
+		}
+
+		for len(picked) < n && len(pool) > 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, contextError(err)
+			}
+			// Take the match out of the pool so it can't be drawn again.
+			j := rng.IntN(len(pool))
+			m := pool[j]
+			pool[j] = pool[len(pool)-1]
+			pool = pool[:len(pool)-1]
+			add(sources[m.source], m.text)
+		}
+		return picked, nil
+	}
+
+	weighSources(sources, opts)
+	eligible := make([][]string, len(sources))
+	loaded := make([]bool, len(sources))
+	for len(picked) < n {
+		if err := ctx.Err(); err != nil {
+			return nil, contextError(err)
+		}
+
+		i := pickSource(rng, sources)
+		if i < 0 {
+			break
+		}
+
+		if !loaded[i] {
+			fortunes, err := s.eligible(sources[i], opts, nil)
+			if err != nil {
+				return nil, err
+			}
+			eligible[i] = fortunes
+			loaded[i] = true
+		}
+
+		pool := eligible[i]
+		if len(pool) == 0 {
+			sources[i].weight = 0
+			continue
+		}
+
+		// Take the fortune out of the pool so it can't be drawn again.
+		j := rng.IntN(len(pool))
+		text := pool[j]
+		pool[j] = pool[len(pool)-1]
+		eligible[i] = pool[:len(pool)-1]
+		add(sources[i], text)
+	}
+	return picked, nil
+}
+
+// eligible returns the fortunes of src that pass the length filters, aren't
+// excluded and, if re is set, match it.
+func (s *NativeService) eligible(src source, opts FortuneOptions, re *regexp.Regexp) ([]string, error) {
+	fortunes, err := src.all()
+	if err != nil {
+		s.logger.Error("Failed to read fortune file", zap.Error(err), zap.String("file", src.db.Path))
+		return nil, fmt.Errorf("could not read fortune file: %w", err)
+	}
+
+	var eligible []string
+	for _, text := range fortunes {
+		if lengthAllowed(text, opts) && (re == nil || re.MatchString(text)) && !s.catalog.excluded(src.db, text, opts) {
+			eligible = append(eligible, text)
+		}
+	}
+	return eligible, nil
+}
+
+func (s *NativeService) GetFortuneByID(ctx context.Context, id string) (*FortuneResponse, error) {
+	return fortuneByID(ctx, s.catalog, id, s.logger)
+}
+
+func (s *NativeService) ListFiles(ctx context.Context, query FileQuery) ([]FileInfo, error) {
+	return listFiles(ctx, s.catalog, query, s.logger)
+}
+
+func (s *NativeService) GetFile(ctx context.Context, name string) (*FileInfo, error) {
+	return getFile(ctx, s.catalog, name, s.logger)
+}
+
+func (s *NativeService) Probabilities(ctx context.Context, opts FortuneOptions) ([]FileProbability, error) {
+	return probabilities(ctx, s.catalog, opts, s.logger)
+}
+
+func (s *NativeService) QueryFortunes(ctx context.Context, query string, opts FortuneOptions, page SearchPage) (*SearchResponse, error) {
+	var results []ranked
+	err := queryIndex(ctx, s.index, s.catalog, query, opts, s.logger, func(r ranked) error {
+		results = append(results, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pageResults(results, querySearch(query), opts, page.forQuery())
+}
+
+func (s *NativeService) StreamQuery(ctx context.Context, query string, opts FortuneOptions, page SearchPage, fn MatchFunc) error {
+	st, err := newStreamer(querySearch(query), opts, page.forQuery(), fn)
+	if err != nil {
+		return err
+	}
+	return st.finish(queryIndex(ctx, s.index, s.catalog, query, opts, s.logger, st.add))
+}
+
+func (s *NativeService) SimilarFortunes(ctx context.Context, id string, opts FortuneOptions, count int) (*SimilarResponse, error) {
+	return similarFortunes(ctx, s.index, s.catalog, id, opts, count, s.logger)
+}
+
+func (s *NativeService) IndexStats() (IndexStats, bool) {
+	return s.index.Stats()
+}
+
+func (s *NativeService) SearchFortunes(ctx context.Context, pattern string, opts FortuneOptions, page SearchPage) (*SearchResponse, error) {
+	page, err := page.forPattern()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ranked
+	err = s.searchPattern(ctx, pattern, opts, func(r ranked) error {
+		results = append(results, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pageResults(results, patternSearch(pattern), opts, page)
+}
+
+func (s *NativeService) StreamSearch(ctx context.Context, pattern string, opts FortuneOptions, page SearchPage, fn MatchFunc) error {
+	page, err := page.forPattern()
+	if err != nil {
+		return err
+	}
+	st, err := newStreamer(patternSearch(pattern), opts, page, fn)
+	if err != nil {
+		return err
+	}
+	err = s.searchPattern(ctx, pattern, opts, st.add)
+	return st.finish(err)
+}
+
+// searchPattern calls fn with the matches of a pattern search in file
+// order.
+func (s *NativeService) searchPattern(ctx context.Context, pattern string, opts FortuneOptions, fn func(ranked) error) error {
+	if pattern == "" {
+		return fmt.Errorf("%w: pattern is required", ErrBadPattern)
+	}
+
+	if ok, err := searchIndexed(ctx, s.index, s.catalog, pattern, opts, s.logger, fn); ok {
+		return err
+	}
+
+	opts.Pattern = pattern
+	sources, err := openSources(ctx, s.catalog, opts, s.logger)
+	if err != nil {
+		return err
+	}
+	return s.scan(ctx, sources, opts, fn)
+}
+
+// search returns every fortune in sources that matches opts.Pattern and the
+// length filters, in file order.
+func (s *NativeService) search(ctx context.Context, sources []source, opts FortuneOptions) ([]SearchMatch, error) {
+	matches := []SearchMatch{}
+	err := s.scan(ctx, sources, opts, func(r ranked) error {
+		matches = append(matches, r.match)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// scan calls fn with every fortune in sources that matches opts.Pattern and
+// the length filters and isn't excluded, in file order, stopping at the first error fn
+// returns.
+func (s *NativeService) scan(ctx context.Context, sources []source, opts FortuneOptions, fn func(ranked) error) error {
+	re, err := compilePattern(opts.Pattern, opts.IgnoreCase)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadPattern, err)
+	}
+
+	for _, src := range sources {
+		if err := ctx.Err(); err != nil {
+			return contextError(err)
+		}
+		fortunes, err := src.all()
+		if err != nil {
+			s.logger.Error("Failed to read fortune file", zap.Error(err), zap.String("file", src.db.Path))
+			return fmt.Errorf("could not read fortune file: %w", err)
+		}
+
+		for i, text := range fortunes {
+			if !lengthAllowed(text, opts) || !re.MatchString(text) || s.catalog.excluded(src.db, text, opts) {
+				continue
+			}
+			trimmed := strings.TrimSpace(text)
+			err := fn(ranked{
+				match: SearchMatch{
+					FortuneResponse: FortuneResponse{
+						ID:         fortuneID(src.db, trimmed),
+						Fortune:    trimmed,
+						SourceFile: src.db.Name,
+					},
+					Index:      i,
+					Highlights: highlights(re, trimmed),
+				},
+				length: len(text),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchAll mirrors `fortune -m`, joining every match into one response.
+func (s *NativeService) matchAll(ctx context.Context, sources []source, opts FortuneOptions) (*FortuneResponse, error) {
+	matches, err := s.search(ctx, sources, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, ErrNoMatch
+	}
+
+	texts := make([]string, len(matches))
+	for i, m := range matches {
+		texts[i] = m.Fortune
+	}
+	return &FortuneResponse{Fortune: strings.Join(texts, "\n%\n")}, nil
+}
@@ -0,0 +1,605 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fortune-api/internal/fortunefile"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// testOffensiveDir is the offensive sub-directory used by the test corpora.
+const testOffensiveDir = "off"
+
+// newTestCatalog returns a catalog over the single directory dir.
+func newTestCatalog(dir string, safeMode bool) *Catalog {
+	return NewCatalog([]Directory{{Path: dir, OffensiveDir: testOffensiveDir}}, safeMode, nil)
+}
+
+// writeTestDatabase creates a fortune database and its index under dir.
+func writeTestDatabase(t *testing.T, dir, name string, fortunes []string, flags uint32) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	_, err := fortunefile.Create(filepath.Join(dir, name), fortunes, flags)
+	require.NoError(t, err)
+}
+
+// newTestCorpus builds a small fortune directory with an offensive
+// sub-directory and returns its path.
+func newTestCorpus(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	writeTestDatabase(t, dir, "animals", []string{
+		"The cat sat on the mat.",
+		"A dog is a man's best friend.",
+		"Cats have nine lives, " + strings.Repeat("and then some more, ", 10) + "or so they say.",
+	}, 0)
+	writeTestDatabase(t, dir, "science", []string{
+		"E = mc^2",
+		"Entropy always increases.",
+	}, fortunefile.FlagRandom)
+	writeTestDatabase(t, filepath.Join(dir, testOffensiveDir), "rude", []string{
+		"An offensive cat joke.",
+	}, fortunefile.FlagRotated)
+
+	// Files without an index are not databases.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a database"), 0o644))
+
+	return dir
+}
+
+func newTestNativeService(t *testing.T) *NativeService {
+	return NewNativeService(newTestCatalog(newTestCorpus(t), false), nil, zap.NewNop())
+}
+
+func TestNativeListFiles(t *testing.T) {
+	s := newTestNativeService(t)
+
+	files, err := s.ListFiles(context.Background(), FileQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"animals", "rude", "science"}, fileNames(files))
+	assert.True(t, files[1].Offensive)
+}
+
+func TestNativeGetFortune(t *testing.T) {
+	s := newTestNativeService(t)
+	ctx := context.Background()
+
+	t.Run("Named file", func(t *testing.T) {
+		resp, err := s.GetFortune(ctx, FortuneOptions{Files: []string{"science"}, ShowCookie: true})
+		require.NoError(t, err)
+		assert.Contains(t, []string{"E = mc^2", "Entropy always increases."}, resp.Fortune)
+		assert.Equal(t, "science", resp.SourceFile)
+	})
+
+	t.Run("Source hidden without show_cookie", func(t *testing.T) {
+		resp, err := s.GetFortune(ctx, FortuneOptions{Files: []string{"science"}})
+		require.NoError(t, err)
+		assert.Empty(t, resp.SourceFile)
+	})
+
+	t.Run("Long only", func(t *testing.T) {
+		resp, err := s.GetFortune(ctx, FortuneOptions{Long: true, Length: 50})
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(resp.Fortune, "Cats have nine lives"))
+	})
+
+	t.Run("Short only", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			resp, err := s.GetFortune(ctx, FortuneOptions{Short: true, Length: 10})
+			require.NoError(t, err)
+			assert.Equal(t, "E = mc^2", resp.Fortune)
+		}
+	})
+
+	t.Run("Percentages", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			resp, err := s.GetFortune(ctx, FortuneOptions{
+				Files:       []string{"animals", "science"},
+				Percentages: []string{"0"},
+				ShowCookie:  true,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, "science", resp.SourceFile)
+		}
+	})
+
+	t.Run("Offensive requires all", func(t *testing.T) {
+		_, err := s.GetFortune(ctx, FortuneOptions{Files: []string{"rude"}})
+		assert.Error(t, err)
+
+		resp, err := s.GetFortune(ctx, FortuneOptions{Files: []string{"rude"}, All: true})
+		require.NoError(t, err)
+		assert.Equal(t, "An offensive cat joke.", resp.Fortune, "rot13 text should be decoded")
+	})
+
+	t.Run("Offensive only", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			resp, err := s.GetFortune(ctx, FortuneOptions{Offensive: OffensiveOnly})
+			require.NoError(t, err)
+			assert.Equal(t, "An offensive cat joke.", resp.Fortune)
+		}
+	})
+
+	t.Run("Pattern returns every match", func(t *testing.T) {
+		resp, err := s.GetFortune(ctx, FortuneOptions{Pattern: "cat", IgnoreCase: true})
+		require.NoError(t, err)
+		assert.Equal(t, 2, len(strings.Split(resp.Fortune, "\n%\n")))
+	})
+
+	t.Run("Size limits", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			resp, err := s.GetFortune(ctx, FortuneOptions{MaxLength: 10})
+			require.NoError(t, err)
+			assert.Equal(t, "E = mc^2", resp.Fortune)
+
+			resp, err = s.GetFortune(ctx, FortuneOptions{MinLength: 100, MaxWidth: 300})
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(resp.Fortune, "Cats have nine lives"))
+		}
+	})
+
+	t.Run("Nothing eligible", func(t *testing.T) {
+		_, err := s.GetFortune(ctx, FortuneOptions{Short: true, Length: 1})
+		assert.ErrorIs(t, err, ErrNoMatch)
+
+		_, err = s.GetFortune(ctx, FortuneOptions{MaxWidth: 5})
+		assert.ErrorIs(t, err, ErrNoMatch)
+	})
+}
+
+func TestNativeGetFortuneSeeded(t *testing.T) {
+	s := newTestNativeService(t)
+	ctx := context.Background()
+
+	first, err := s.GetFortune(ctx, FortuneOptions{Seed: "replay", All: true})
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		resp, err := s.GetFortune(ctx, FortuneOptions{Seed: "replay", All: true})
+		require.NoError(t, err)
+		assert.Equal(t, first, resp)
+	}
+
+	// An unseeded draw reports the seed it used, which replays it.
+	for i := 0; i < 10; i++ {
+		resp, err := s.GetFortune(ctx, FortuneOptions{Equal: true})
+		require.NoError(t, err)
+		require.NotEmpty(t, resp.Seed)
+
+		replay, err := s.GetFortune(ctx, FortuneOptions{Equal: true, Seed: resp.Seed})
+		require.NoError(t, err)
+		assert.Equal(t, resp, replay)
+	}
+
+	search, err := s.SearchFortunes(ctx, "cat", FortuneOptions{Seed: "replay"}, SearchPage{})
+	require.NoError(t, err)
+	assert.Equal(t, "replay", search.Seed)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		resp, err := s.GetFortune(ctx, FortuneOptions{Seed: fmt.Sprint("seed-", i)})
+		require.NoError(t, err)
+		seen[resp.Fortune] = true
+	}
+	assert.Greater(t, len(seen), 1, "different seeds should pick different fortunes")
+}
+
+func TestNativeGetFortuneMatch(t *testing.T) {
+	s := newTestNativeService(t)
+	ctx := context.Background()
+
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		resp, err := s.GetFortune(ctx, FortuneOptions{Match: "cat", IgnoreCase: true, Offensive: OffensiveInclude})
+		require.NoError(t, err)
+		assert.Contains(t, strings.ToLower(resp.Fortune), "cat")
+		assert.NotContains(t, resp.Fortune, "\n%\n", "a match is a single fortune")
+		seen[resp.Fortune] = true
+	}
+	assert.Len(t, seen, 3, "every matching fortune should come up")
+
+	resp, err := s.GetFortune(ctx, FortuneOptions{Match: "cat", IgnoreCase: true, Short: true, Files: []string{"animals"}})
+	require.NoError(t, err)
+	assert.Equal(t, "The cat sat on the mat.", resp.Fortune)
+
+	_, err = s.GetFortune(ctx, FortuneOptions{Match: "cat", Files: []string{"science"}})
+	assert.ErrorIs(t, err, ErrNoMatch)
+
+	_, err = s.GetFortune(ctx, FortuneOptions{Match: "("})
+	assert.ErrorIs(t, err, ErrBadPattern)
+}
+
+func TestNativeGetFortuneMatchUniform(t *testing.T) {
+	// One match among many fortunes must not win just because its file is
+	// large: every match is equally likely.
+	dir := t.TempDir()
+	big := make([]string, 100)
+	for i := range big {
+		big[i] = fmt.Sprintf("Filler fortune number %d.", i)
+	}
+	big[50] = "The lone needle in the haystack."
+	writeTestDatabase(t, dir, "big", big, 0)
+	small := make([]string, 9)
+	for i := range small {
+		small[i] = fmt.Sprintf("Needle number %d.", i)
+	}
+	writeTestDatabase(t, dir, "small", small, 0)
+
+	s := NewNativeService(newTestCatalog(dir, false), nil, zap.NewNop())
+	ctx := context.Background()
+
+	const draws = 1000
+	counts := make(map[string]int)
+	for i := 0; i < draws; i++ {
+		resp, err := s.GetFortune(ctx, FortuneOptions{Match: "needle", IgnoreCase: true, Seed: fmt.Sprintf("uniform-%d", i)})
+		require.NoError(t, err)
+		counts[resp.Fortune]++
+	}
+
+	assert.Len(t, counts, 10)
+	for fortune, n := range counts {
+		assert.InDelta(t, draws/10, n, 40, "%q drawn %d times", fortune, n)
+	}
+}
+
+func TestNativeGetFortuneExclusionsWeighed(t *testing.T) {
+	// Files are weighed by the fortunes left once some are excluded, so the
+	// one remaining fortune of a mostly excluded file is as likely as any.
+	dir := t.TempDir()
+	var a, b []string
+	for i := 0; i < 10; i++ {
+		a = append(a, fmt.Sprintf("Fortune A%d.", i))
+		b = append(b, fmt.Sprintf("Fortune B%d.", i))
+	}
+	writeTestDatabase(t, dir, "a", a, 0)
+	writeTestDatabase(t, dir, "b", b, 0)
+
+	var excluded []string
+	for _, text := range a[1:] {
+		excluded = append(excluded, fortuneID(database{Name: "a"}, text))
+	}
+
+	s := NewNativeService(newTestCatalog(dir, false), nil, zap.NewNop())
+	ctx := context.Background()
+
+	const draws = 1100
+	lone := 0
+	for i := 0; i < draws; i++ {
+		resp, err := s.GetFortune(ctx, FortuneOptions{ExcludeIDs: excluded, Seed: fmt.Sprintf("weighed-%d", i)})
+		require.NoError(t, err)
+		if resp.Fortune == a[0] {
+			lone++
+		}
+	}
+	assert.InDelta(t, draws/11, lone, 40)
+}
+
+func TestNativeGetFortunes(t *testing.T) {
+	s := newTestNativeService(t)
+	ctx := context.Background()
+
+	t.Run("Distinct", func(t *testing.T) {
+		resp, err := s.GetFortunes(ctx, FortuneOptions{ShowCookie: true}, 5)
+		require.NoError(t, err)
+		assert.Equal(t, 5, resp.Count)
+		assert.False(t, resp.Partial)
+
+		seen := make(map[string]bool)
+		for _, f := range resp.Fortunes {
+			assert.False(t, seen[f.Fortune], "duplicate %q", f.Fortune)
+			seen[f.Fortune] = true
+			assert.NotEmpty(t, f.SourceFile)
+		}
+	})
+
+	t.Run("Partial", func(t *testing.T) {
+		resp, err := s.GetFortunes(ctx, FortuneOptions{Files: []string{"science"}}, 5)
+		require.NoError(t, err)
+		assert.Equal(t, 2, resp.Count)
+		assert.True(t, resp.Partial)
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		resp, err := s.GetFortunes(ctx, FortuneOptions{Pattern: "cat", IgnoreCase: true, Short: true, Offensive: OffensiveInclude}, 5)
+		require.NoError(t, err)
+		assert.Equal(t, 2, resp.Count, "the long cat fortune is filtered out")
+		assert.True(t, resp.Partial)
+	})
+
+	t.Run("Size limits", func(t *testing.T) {
+		resp, err := s.GetFortunes(ctx, FortuneOptions{MinLength: 20, MaxLength: 28}, 5)
+		require.NoError(t, err)
+		assert.Equal(t, 2, resp.Count)
+		for _, f := range resp.Fortunes {
+			assert.Contains(t, []string{"The cat sat on the mat.", "Entropy always increases."}, f.Fortune)
+		}
+	})
+
+	t.Run("Exclusions", func(t *testing.T) {
+		dog := fortuneID(database{Name: "animals"}, "A dog is a man's best friend.")
+		resp, err := s.GetFortunes(ctx, FortuneOptions{ExcludeFiles: []string{"science"}, ExcludeIDs: []string{dog}}, 5)
+		require.NoError(t, err)
+		assert.Equal(t, 2, resp.Count)
+		for _, f := range resp.Fortunes {
+			assert.NotEqual(t, dog, f.ID)
+			assert.True(t, strings.HasPrefix(f.ID, "animals-"))
+		}
+
+		_, err = s.GetFortunes(ctx, FortuneOptions{ExcludeFiles: []string{"animals", "science"}}, 5)
+		assert.ErrorIs(t, err, ErrNoMatch)
+
+		var verr *ValidationError
+		_, err = s.GetFortunes(ctx, FortuneOptions{ExcludeFiles: []string{"zebras"}}, 5)
+		assert.ErrorAs(t, err, &verr)
+	})
+
+	t.Run("Seeded", func(t *testing.T) {
+		first, err := s.GetFortunes(ctx, FortuneOptions{Seed: "carousel"}, 3)
+		require.NoError(t, err)
+		again, err := s.GetFortunes(ctx, FortuneOptions{Seed: "carousel"}, 3)
+		require.NoError(t, err)
+		assert.Equal(t, first, again)
+		assert.Equal(t, "carousel", first.Seed)
+	})
+
+	t.Run("No match", func(t *testing.T) {
+		_, err := s.GetFortunes(ctx, FortuneOptions{Pattern: "zebra"}, 3)
+		assert.ErrorIs(t, err, ErrNoMatch)
+	})
+
+	t.Run("Bad pattern", func(t *testing.T) {
+		_, err := s.GetFortunes(ctx, FortuneOptions{Pattern: "("}, 3)
+		assert.ErrorIs(t, err, ErrBadPattern)
+	})
+}
+
+func TestNativeSearchFortunes(t *testing.T) {
+	s := newTestNativeService(t)
+	ctx := context.Background()
+
+	t.Run("Case sensitive", func(t *testing.T) {
+		resp, err := s.SearchFortunes(ctx, "cat", FortuneOptions{}, SearchPage{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, resp.Count)
+		assert.Equal(t, "The cat sat on the mat.", resp.Matches[0].Fortune)
+		assert.Equal(t, "animals", resp.Matches[0].SourceFile)
+	})
+
+	t.Run("Ignore case with offensive", func(t *testing.T) {
+		resp, err := s.SearchFortunes(ctx, "cat", FortuneOptions{IgnoreCase: true, All: true}, SearchPage{})
+		require.NoError(t, err)
+		assert.Equal(t, 3, resp.Count)
+	})
+
+	t.Run("Positions and highlights", func(t *testing.T) {
+		resp, err := s.SearchFortunes(ctx, "cat", FortuneOptions{IgnoreCase: true, Offensive: OffensiveInclude}, SearchPage{})
+		require.NoError(t, err)
+		require.Equal(t, 3, resp.Count)
+
+		cats := resp.Matches[1]
+		assert.Equal(t, "animals", cats.SourceFile)
+		assert.Equal(t, 2, cats.Index)
+		assert.Equal(t, []Span{{Start: 0, End: 3}}, cats.Highlights)
+		assert.Equal(t, fortuneID(database{Name: "animals"}, cats.Fortune), cats.ID)
+
+		rude := resp.Matches[2]
+		assert.Equal(t, "rude", rude.SourceFile)
+		assert.Equal(t, 0, rude.Index)
+		assert.Equal(t, []Span{{Start: 13, End: 16}}, rude.Highlights)
+	})
+
+	t.Run("Restricted to files", func(t *testing.T) {
+		resp, err := s.SearchFortunes(ctx, "e", FortuneOptions{Files: []string{"science"}}, SearchPage{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, resp.Count)
+	})
+
+	t.Run("Invalid pattern", func(t *testing.T) {
+		_, err := s.SearchFortunes(ctx, "(", FortuneOptions{}, SearchPage{})
+		assert.ErrorIs(t, err, ErrBadPattern)
+	})
+
+	t.Run("Empty pattern", func(t *testing.T) {
+		_, err := s.SearchFortunes(ctx, "", FortuneOptions{}, SearchPage{})
+		assert.ErrorIs(t, err, ErrBadPattern)
+	})
+
+	t.Run("Paged by length", func(t *testing.T) {
+		opts := FortuneOptions{IgnoreCase: true, All: true}
+		resp, err := s.SearchFortunes(ctx, "cat", opts, SearchPage{Sort: SearchByLength, Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, 2, resp.Count)
+		assert.Equal(t, 3, resp.Total)
+		assert.Equal(t, "An offensive cat joke.", resp.Matches[0].Fortune)
+
+		next, err := s.SearchFortunes(ctx, "cat", opts, SearchPage{Sort: SearchByLength, Limit: 2, Cursor: resp.NextCursor})
+		require.NoError(t, err)
+		require.Equal(t, 1, next.Count)
+		assert.Equal(t, 2, next.Matches[0].Index, "the longest comes last")
+		assert.Empty(t, next.NextCursor)
+	})
+
+	t.Run("Exclusions", func(t *testing.T) {
+		mat := fortuneID(database{Name: "animals"}, "The cat sat on the mat.")
+		resp, err := s.SearchFortunes(ctx, "cat", FortuneOptions{IgnoreCase: true, All: true, ExcludeFiles: []string{"rude"}, ExcludeIDs: []string{mat}}, SearchPage{})
+		require.NoError(t, err)
+		require.Equal(t, 1, resp.Count)
+		assert.Equal(t, 2, resp.Matches[0].Index)
+	})
+
+	t.Run("Size limits", func(t *testing.T) {
+		resp, err := s.SearchFortunes(ctx, "cat", FortuneOptions{IgnoreCase: true, All: true, MaxLength: 22}, SearchPage{})
+		require.NoError(t, err)
+		require.Equal(t, 1, resp.Count)
+		assert.Equal(t, "An offensive cat joke.", resp.Matches[0].Fortune)
+	})
+
+	t.Run("Facets", func(t *testing.T) {
+		opts := FortuneOptions{IgnoreCase: true, All: true}
+		resp, err := s.SearchFortunes(ctx, "cat", opts, SearchPage{Limit: 1, Facets: []string{FacetFile, FacetLength}})
+		require.NoError(t, err)
+		require.NotNil(t, resp.Facets)
+		assert.Equal(t, map[string]int{"animals": 2, "rude": 1}, resp.Facets.File)
+		assert.Equal(t, map[string]int{LengthShort: 2, LengthMedium: 1, LengthLong: 0}, resp.Facets.Length)
+
+		short, err := s.SearchFortunes(ctx, "cat", FortuneOptions{IgnoreCase: true, All: true, Short: true}, SearchPage{})
+		require.NoError(t, err)
+		assert.Equal(t, resp.Facets.Length[LengthShort], short.Total, "short matches the short filter")
+	})
+
+	t.Run("No relevance for patterns", func(t *testing.T) {
+		_, err := s.SearchFortunes(ctx, "cat", FortuneOptions{}, SearchPage{Sort: SearchByRelevance})
+		assert.True(t, isValidationError(err))
+	})
+}
+
+func TestNativeSearchPlainPattern(t *testing.T) {
+	dir := newTestCorpus(t)
+	writeTestDatabase(t, dir, "letters", []string{"Ὁ λόγος.", "The Congreſs shall make no law."}, 0)
+	catalog := newTestCatalog(dir, false)
+	index := NewIndex(catalog, zap.NewNop())
+	require.NoError(t, index.Build(context.Background()))
+	scanned := NewNativeService(catalog, nil, zap.NewNop())
+	indexed := NewNativeService(catalog, index, zap.NewNop())
+
+	testCases := []struct {
+		name    string
+		pattern string
+		opts    FortuneOptions
+		count   int
+	}{
+		{name: "Part of a word", pattern: "at", count: 2},
+		{name: "Phrase", pattern: "on the"},
+		{name: "Across punctuation", pattern: "lives, and"},
+		{name: "Case sensitive", pattern: "Cat"},
+		{name: "Ignore case", pattern: "cat", opts: FortuneOptions{IgnoreCase: true}},
+		{name: "Offensive", pattern: "cat", opts: FortuneOptions{Offensive: OffensiveOnly}},
+		{name: "Short only", pattern: "a", opts: FortuneOptions{Short: true}},
+		{name: "No match", pattern: "zebra"},
+		{name: "Final sigma ignoring case", pattern: "ΛΌΓΟΣ", opts: FortuneOptions{IgnoreCase: true}, count: 1},
+		{name: "Long s ignoring case", pattern: "congress", opts: FortuneOptions{IgnoreCase: true}, count: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected, err := scanned.SearchFortunes(context.Background(), tc.pattern, tc.opts, SearchPage{})
+			require.NoError(t, err)
+			actual, err := indexed.SearchFortunes(context.Background(), tc.pattern, tc.opts, SearchPage{})
+			require.NoError(t, err)
+			assert.Equal(t, expected, actual)
+			if tc.count > 0 {
+				assert.Equal(t, tc.count, actual.Count)
+			}
+		})
+	}
+
+	// Rewrite a file behind the index's back: plain patterns see the
+	// indexed fortunes, regular expressions the file.
+	writeTestDatabase(t, dir, "animals", []string{"A catfish."}, 0)
+
+	resp, err := indexed.SearchFortunes(context.Background(), "cat", FortuneOptions{}, SearchPage{})
+	require.NoError(t, err)
+	require.Equal(t, 1, resp.Count)
+	assert.Equal(t, "The cat sat on the mat.", resp.Matches[0].Fortune)
+
+	resp, err = indexed.SearchFortunes(context.Background(), "c[a]t", FortuneOptions{}, SearchPage{})
+	require.NoError(t, err)
+	require.Equal(t, 1, resp.Count)
+	assert.Equal(t, "A catfish.", resp.Matches[0].Fortune)
+}
+
+func TestNativeStreamSearch(t *testing.T) {
+	s := newTestNativeService(t)
+	ctx := context.Background()
+	opts := FortuneOptions{IgnoreCase: true, All: true}
+
+	collect := func(page SearchPage) ([]string, error) {
+		var files []string
+		err := s.StreamSearch(ctx, "cat", opts, page, func(m SearchMatch) error {
+			files = append(files, m.SourceFile)
+			return nil
+		})
+		return files, err
+	}
+
+	files, err := collect(SearchPage{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"animals", "animals", "rude"}, files, "every match, in file order")
+
+	files, err = collect(SearchPage{Offset: 1, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"animals"}, files)
+
+	_, err = collect(SearchPage{Sort: SearchByRelevance})
+	assert.True(t, isValidationError(err))
+
+	t.Run("Stops when fn fails", func(t *testing.T) {
+		calls := 0
+		err := s.StreamSearch(ctx, "cat", opts, SearchPage{}, func(SearchMatch) error {
+			calls++
+			return context.Canceled
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestNativeSafeMode(t *testing.T) {
+	s := NewNativeService(newTestCatalog(newTestCorpus(t), true), nil, zap.NewNop())
+	ctx := context.Background()
+
+	files, err := s.ListFiles(ctx, FileQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"animals", "science"}, fileNames(files))
+
+	_, err = s.GetFortune(ctx, FortuneOptions{Offensive: OffensiveOnly})
+	assert.ErrorIs(t, err, ErrOffensiveDisabled)
+
+	resp, err := s.SearchFortunes(ctx, "cat", FortuneOptions{All: true, IgnoreCase: true}, SearchPage{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.Count, "all=true must not reach offensive files in safe mode")
+}
+
+func TestNativeUnavailable(t *testing.T) {
+	s := NewNativeService(newTestCatalog(filepath.Join(t.TempDir(), "missing"), false), nil, zap.NewNop())
+
+	_, err := s.GetFortune(context.Background(), FortuneOptions{})
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+}
+
+func TestNativeDeadline(t *testing.T) {
+	s := newTestNativeService(t)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	_, err := s.GetFortune(ctx, FortuneOptions{})
+	assert.ErrorIs(t, err, ErrBackendTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestNativeCancelled(t *testing.T) {
+	s := newTestNativeService(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.GetFortune(ctx, FortuneOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = s.SearchFortunes(ctx, "cat", FortuneOptions{}, SearchPage{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	err = s.StreamSearch(ctx, "cat", FortuneOptions{}, SearchPage{}, func(SearchMatch) error { return nil })
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = s.ListFiles(ctx, FileQuery{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
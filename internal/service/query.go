@@ -0,0 +1,424 @@
+package service
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// token is a word of a fortune, lower-cased, with its byte range in the
+// original text.
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into words: runs of letters and digits. Everything
+// else separates words.
+func tokenize(text string) []token {
+	var (
+		tokens []token
+		b      strings.Builder
+		start  = -1
+	)
+	flush := func(end int) {
+		if start >= 0 {
+			tokens = append(tokens, token{term: b.String(), start: start, end: end})
+			b.Reset()
+			start = -1
+		}
+	}
+
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		flush(i)
+	}
+	flush(len(text))
+	return tokens
+}
+
+// terms returns the terms of tokens in order.
+func terms(tokens []token) []string {
+	out := make([]string, len(tokens))
+	for i, t := range tokens {
+		out[i] = t.term
+	}
+	return out
+}
+
+// candidate is a fortune being tested against a query.
+type candidate struct {
+	db database
+	// raw is the fortune as stored, which the length filters measure.
+	raw string
+	// text is the fortune as returned; spans index into it.
+	text   string
+	tokens []token
+	// author is the index of the first token of the attribution, or -1 if
+	// the fortune has none.
+	author int
+}
+
+func newCandidate(db database, raw string) *candidate {
+	text := strings.TrimSpace(raw)
+	c := &candidate{db: db, raw: raw, text: text, tokens: tokenize(text), author: -1}
+	if at := attribution(text); at >= 0 {
+		c.author = sort.Search(len(c.tokens), func(i int) bool { return c.tokens[i].start >= at })
+	}
+	return c
+}
+
+// attribution returns the byte offset of the author named on the last line
+// of text, as in "\t\t-- Mark Twain", or -1 if there is none.
+func attribution(text string) int {
+	lineStart := strings.LastIndexByte(text, '\n') + 1
+	line := text[lineStart:]
+	body := strings.TrimLeft(line, " \t")
+	for _, dash := range []string{"--", "—", "―"} {
+		if strings.HasPrefix(body, dash) {
+			return lineStart + len(line) - len(body) + len(dash)
+		}
+	}
+	return -1
+}
+
+// queryNode is a node of a parsed search query.
+type queryNode interface {
+	// expand looks up the indexed words each term may match, which is more
+	// than the term itself when it is fuzzy. It runs before eval and
+	// candidates.
+	expand(snap *snapshot, f fuzziness)
+	// eval reports whether c satisfies the node, and the hits that made it
+	// so.
+	eval(c *candidate) ([]hit, bool)
+	// candidates narrows the search to the documents that could satisfy
+	// the node, in index order. all is true if it cannot narrow it at all.
+	candidates(snap *snapshot) (ids []int32, all bool)
+	String() string
+}
+
+// hit is a stretch of a fortune that satisfied a term. words pairs each
+// word of the term with the word it matched; Distance is zero where they
+// are the same.
+type hit struct {
+	span  Span
+	words []FuzzyMatch
+}
+
+// andNode is satisfied when every child is.
+type andNode struct{ children []queryNode }
+
+// orNode is satisfied when any child is.
+type orNode struct{ children []queryNode }
+
+// notNode is satisfied when its child is not.
+type notNode struct{ child queryNode }
+
+// termNode matches a word, or a phrase whose words must appear next to each
+// other and in order. Matching ignores case and punctuation.
+type termNode struct {
+	phrase []string
+	// fuzz is the edit distance given with ~, or fuzzUnset.
+	fuzz int
+	// variants holds, for each word of the phrase, the words it matches
+	// and how far away they are. It is filled in by expand.
+	variants []map[string]int
+}
+
+// authorNode matches a word or phrase in the fortune's attribution line.
+type authorNode struct{ termNode }
+
+// fileNode matches fortunes from the files whose names match a glob.
+type fileNode struct{ pattern string }
+
+// lenNode matches fortunes whose length in bytes lies in [min, max].
+type lenNode struct {
+	min, max int
+	// op is the comparison as written, kept for String.
+	op string
+}
+
+func (n *andNode) expand(snap *snapshot, f fuzziness) {
+	for _, child := range n.children {
+		child.expand(snap, f)
+	}
+}
+
+func (n *orNode) expand(snap *snapshot, f fuzziness) {
+	for _, child := range n.children {
+		child.expand(snap, f)
+	}
+}
+
+func (n *notNode) expand(snap *snapshot, f fuzziness) { n.child.expand(snap, f) }
+
+func (n *termNode) expand(snap *snapshot, f fuzziness) {
+	n.variants = make([]map[string]int, len(n.phrase))
+	for i, term := range n.phrase {
+		n.variants[i] = snap.variants(term, f.distanceFor(term, n.fuzz))
+	}
+}
+
+func (n *fileNode) expand(*snapshot, fuzziness) {}
+
+func (n *lenNode) expand(*snapshot, fuzziness) {}
+
+func (n *andNode) eval(c *candidate) ([]hit, bool) {
+	var hits []hit
+	for _, child := range n.children {
+		h, ok := child.eval(c)
+		if !ok {
+			return nil, false
+		}
+		hits = append(hits, h...)
+	}
+	return hits, true
+}
+
+func (n *orNode) eval(c *candidate) ([]hit, bool) {
+	var hits []hit
+	matched := false
+	for _, child := range n.children {
+		if h, ok := child.eval(c); ok {
+			hits = append(hits, h...)
+			matched = true
+		}
+	}
+	return hits, matched
+}
+
+func (n *notNode) eval(c *candidate) ([]hit, bool) {
+	_, ok := n.child.eval(c)
+	return nil, !ok
+}
+
+func (n *termNode) eval(c *candidate) ([]hit, bool) {
+	hits := n.phraseHits(c.tokens)
+	return hits, len(hits) > 0
+}
+
+func (n *authorNode) eval(c *candidate) ([]hit, bool) {
+	if c.author < 0 {
+		return nil, false
+	}
+	hits := n.phraseHits(c.tokens[c.author:])
+	return hits, len(hits) > 0
+}
+
+func (n *fileNode) eval(c *candidate) ([]hit, bool) {
+	ok, _ := path.Match(n.pattern, c.db.Name)
+	return nil, ok
+}
+
+func (n *lenNode) eval(c *candidate) ([]hit, bool) {
+	return nil, len(c.raw) >= n.min && len(c.raw) <= n.max
+}
+
+func (n *andNode) candidates(snap *snapshot) ([]int32, bool) {
+	var lists [][]int32
+	for _, child := range n.children {
+		ids, all := child.candidates(snap)
+		if all {
+			continue
+		}
+		if len(ids) == 0 {
+			return nil, false
+		}
+		lists = append(lists, ids)
+	}
+	if len(lists) == 0 {
+		return nil, true
+	}
+	return intersectAll(lists), false
+}
+
+func (n *orNode) candidates(snap *snapshot) ([]int32, bool) {
+	var ids []int32
+	for _, child := range n.children {
+		childIDs, all := child.candidates(snap)
+		if all {
+			return nil, true
+		}
+		ids = union(ids, childIDs)
+	}
+	return ids, false
+}
+
+func (n *notNode) candidates(*snapshot) ([]int32, bool) { return nil, true }
+
+// candidates returns the documents holding a variant of every word of the
+// phrase. The words of an attribution are indexed like the rest of the
+// text, so this serves authorNode too.
+func (n *termNode) candidates(snap *snapshot) ([]int32, bool) {
+	lists := make([][]int32, len(n.variants))
+	for i, variants := range n.variants {
+		for term := range variants {
+			lists[i] = union(lists[i], snap.postings[term])
+		}
+		if len(lists[i]) == 0 {
+			return nil, false
+		}
+	}
+	return intersectAll(lists), false
+}
+
+func (n *fileNode) candidates(*snapshot) ([]int32, bool) { return nil, true }
+
+func (n *lenNode) candidates(*snapshot) ([]int32, bool) { return nil, true }
+
+func (n *andNode) String() string { return joinNodes(n.children, " AND ") }
+
+func (n *orNode) String() string { return joinNodes(n.children, " OR ") }
+
+func (n *notNode) String() string { return "NOT " + n.child.String() }
+
+func (n *termNode) String() string {
+	switch n.fuzz {
+	case fuzzUnset:
+		return quotePhrase(n.phrase)
+	case fuzzAuto:
+		return quotePhrase(n.phrase) + "~"
+	}
+	return quotePhrase(n.phrase) + "~" + strconv.Itoa(n.fuzz)
+}
+
+func (n *authorNode) String() string { return "author:" + n.termNode.String() }
+
+func (n *fileNode) String() string { return "file:" + n.pattern }
+
+func (n *lenNode) String() string { return "len:" + n.op }
+
+func joinNodes(nodes []queryNode, sep string) string {
+	parts := make([]string, len(nodes))
+	for i, n := range nodes {
+		parts[i] = n.String()
+	}
+	return "(" + strings.Join(parts, sep) + ")"
+}
+
+func quotePhrase(phrase []string) string {
+	return fmt.Sprintf("%q", strings.Join(phrase, " "))
+}
+
+// phraseHits returns every occurrence of the phrase in tokens.
+func (n *termNode) phraseHits(tokens []token) []hit {
+	var hits []hit
+	for i := 0; i+len(n.phrase) <= len(tokens); i++ {
+		if h, ok := n.phraseAt(tokens, i); ok {
+			hits = append(hits, h)
+		}
+	}
+	return hits
+}
+
+// phraseAt matches the phrase against the tokens starting at i.
+func (n *termNode) phraseAt(tokens []token, i int) (hit, bool) {
+	words := make([]FuzzyMatch, len(n.phrase))
+	for j, term := range n.phrase {
+		d, ok := n.variants[j][tokens[i+j].term]
+		if !ok {
+			return hit{}, false
+		}
+		words[j] = FuzzyMatch{Term: term, Matched: tokens[i+j].term, Distance: d}
+	}
+	span := Span{Start: tokens[i].start, End: tokens[i+len(n.phrase)-1].end}
+	return hit{span: span, words: words}, true
+}
+
+// queryMatch is how a fortune satisfied a query.
+type queryMatch struct {
+	spans []Span
+	fuzzy []FuzzyMatch
+	// distance is the edits the closest match of each query term needed,
+	// added up; it is zero when every term matched exactly.
+	distance int
+	score    float64
+}
+
+// matchQuery reports whether c satisfies q, which must have been expanded
+// over snap, and returns the byte ranges of every hit in order.
+func matchQuery(q queryNode, snap *snapshot, c *candidate) (queryMatch, bool) {
+	hits, ok := q.eval(c)
+	if !ok {
+		return queryMatch{}, false
+	}
+
+	return queryMatch{
+		spans:    hitSpans(hits),
+		fuzzy:    hitFuzzy(hits),
+		distance: hitDistance(hits),
+		score:    snap.bm25(hits, len(c.tokens)),
+	}, true
+}
+
+func hitSpans(hits []hit) []Span {
+	spans := make([]Span, len(hits))
+	for i, h := range hits {
+		spans[i] = h.span
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].Start != spans[j].Start {
+			return spans[i].Start < spans[j].Start
+		}
+		return spans[i].End < spans[j].End
+	})
+	unique := []Span{}
+	for _, span := range spans {
+		if len(unique) == 0 || span != unique[len(unique)-1] {
+			unique = append(unique, span)
+		}
+	}
+	return unique
+}
+
+// hitFuzzy lists each approximate match once, by term and then by
+// closeness.
+func hitFuzzy(hits []hit) []FuzzyMatch {
+	seen := make(map[FuzzyMatch]bool)
+	var fuzzy []FuzzyMatch
+	for _, h := range hits {
+		for _, f := range h.words {
+			if f.Distance > 0 && !seen[f] {
+				seen[f] = true
+				fuzzy = append(fuzzy, f)
+			}
+		}
+	}
+	sort.Slice(fuzzy, func(i, j int) bool {
+		if fuzzy[i].Term != fuzzy[j].Term {
+			return fuzzy[i].Term < fuzzy[j].Term
+		}
+		if fuzzy[i].Distance != fuzzy[j].Distance {
+			return fuzzy[i].Distance < fuzzy[j].Distance
+		}
+		return fuzzy[i].Matched < fuzzy[j].Matched
+	})
+	return fuzzy
+}
+
+// hitDistance adds up the edits of the closest match of every term, so a
+// term that also matched exactly counts for nothing.
+func hitDistance(hits []hit) int {
+	closest := make(map[string]int)
+	for _, h := range hits {
+		for _, f := range h.words {
+			if d, ok := closest[f.Term]; !ok || f.Distance < d {
+				closest[f.Term] = f.Distance
+			}
+		}
+	}
+	total := 0
+	for _, d := range closest {
+		total += d
+	}
+	return total
+}
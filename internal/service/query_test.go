@@ -0,0 +1,108 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens := tokenize("A dog's LIFE, e=mc²!")
+	assert.Equal(t, []string{"a", "dog", "s", "life", "e", "mc"}, terms(tokens))
+	assert.Equal(t, token{term: "life", start: 8, end: 12}, tokens[3])
+}
+
+func TestAttribution(t *testing.T) {
+	testCases := []struct {
+		text     string
+		expected int
+	}{
+		{text: "Be yourself.\n\t\t-- Oscar Wilde", expected: 17},
+		{text: "Be yourself.\n— Oscar Wilde", expected: 16},
+		{text: "-- Anonymous", expected: 2},
+		{text: "Two -- dashes mid-line.", expected: -1},
+		{text: "No author here.", expected: -1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.text, func(t *testing.T) {
+			assert.Equal(t, tc.expected, attribution(tc.text))
+		})
+	}
+}
+
+func TestMatchQuery(t *testing.T) {
+	db := database{Name: "quotes"}
+	text := "Cats have nine lives; this cat has nine.\n\t\t-- Mark Twain"
+
+	testCases := []struct {
+		query    string
+		matched  bool
+		expected []Span
+	}{
+		{query: `cat "nine lives"`, matched: true, expected: []Span{{Start: 10, End: 20}, {Start: 27, End: 30}}},
+		{query: `"lives nine"`, matched: false},
+		{query: "dog OR cat", matched: true, expected: []Span{{Start: 27, End: 30}}},
+		{query: "cat NOT dog", matched: true, expected: []Span{{Start: 27, End: 30}}},
+		{query: "cat -nine", matched: false},
+		{query: "author:twain", matched: true, expected: []Span{{Start: 51, End: 56}}},
+		{query: `author:"mark twain"`, matched: true, expected: []Span{{Start: 46, End: 56}}},
+		{query: "author:cats", matched: false},
+		{query: "file:quo*", matched: true, expected: []Span{}},
+		{query: "file:science", matched: false},
+		{query: "len:<100", matched: true, expected: []Span{}},
+		{query: "len:>=100", matched: false},
+		{query: "len:50..60", matched: true, expected: []Span{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			q, err := parseQuery(tc.query)
+			require.NoError(t, err)
+			c := newCandidate(db, text+"\n")
+			snap := snapshotOf(c)
+			q.expand(snap, fuzziness{})
+
+			m, ok := matchQuery(q, snap, c)
+			assert.Equal(t, tc.matched, ok)
+			if tc.matched {
+				assert.Equal(t, tc.expected, m.spans)
+				assert.Empty(t, m.fuzzy)
+			}
+		})
+	}
+}
+
+func TestMatchQuery_Fuzzy(t *testing.T) {
+	c := newCandidate(database{Name: "quotes"}, "Einstein met Einstien, then Eisntein.\n\t\t-- Anon")
+
+	q, err := parseQuery("einstien~1 anno~")
+	require.NoError(t, err)
+	snap := snapshotOf(c)
+	q.expand(snap, fuzziness{})
+
+	m, ok := matchQuery(q, snap, c)
+	require.True(t, ok)
+	assert.Equal(t, []Span{{Start: 0, End: 8}, {Start: 13, End: 21}, {Start: 43, End: 47}}, m.spans,
+		"eisntein is two swaps away")
+	assert.Equal(t, []FuzzyMatch{
+		{Term: "anno", Matched: "anon", Distance: 1},
+		{Term: "einstien", Matched: "einstein", Distance: 1},
+	}, m.fuzzy)
+	assert.Equal(t, 1, m.distance, "einstien also matched exactly")
+}
+
+// snapshotOf indexes the words of a single candidate.
+func snapshotOf(c *candidate) *snapshot {
+	snap := &snapshot{postings: make(map[string][]int32)}
+	for _, t := range c.tokens {
+		snap.postings[t.term] = []int32{0}
+	}
+	return snap
+}
+
+func TestUnion(t *testing.T) {
+	assert.Equal(t, []int32{1, 2, 3, 5, 8}, union([]int32{1, 3, 8}, []int32{2, 3, 5}))
+	assert.Equal(t, []int32{4}, union(nil, []int32{4}))
+}
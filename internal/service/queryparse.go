@@ -0,0 +1,477 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxQueryDepth bounds how deeply groups and NOTs may nest.
+const maxQueryDepth = 32
+
+// QuerySyntaxError reports a search query that could not be parsed.
+// Position counts characters from the start of the query, from zero.
+type QuerySyntaxError struct {
+	Query    string
+	Position int
+	Message  string
+}
+
+func (e *QuerySyntaxError) Error() string {
+	return fmt.Sprintf("query syntax error at position %d: %s", e.Position, e.Message)
+}
+
+// Unwrap lets callers treat syntax errors like any other bad pattern.
+func (e *QuerySyntaxError) Unwrap() error {
+	return ErrBadPattern
+}
+
+type lexKind int
+
+const (
+	lexEOF lexKind = iota
+	lexWord
+	lexPhrase
+	lexField
+	lexAnd
+	lexOr
+	lexNot
+	lexLParen
+	lexRParen
+)
+
+// lexeme is a token of the query language. pos is a byte offset into the
+// query; for fields, valuePos is where the value starts. fuzz is the edit
+// distance given with ~ after a word or phrase.
+type lexeme struct {
+	kind     lexKind
+	text     string
+	pos      int
+	field    string
+	valuePos int
+	fuzz     int
+}
+
+func (l lexeme) describe() string {
+	switch l.kind {
+	case lexEOF:
+		return "end of query"
+	case lexPhrase:
+		return fmt.Sprintf("%q", l.text)
+	case lexField:
+		return l.field + ":" + l.text
+	}
+	return l.text
+}
+
+// queryFields are the qualifiers a term may carry, as in file:science.
+var queryFields = map[string]bool{"file": true, "len": true, "author": true}
+
+// queryParser turns a query into a tree of queryNodes. The grammar is
+//
+//	query   = or
+//	or      = and { "OR" and }
+//	and     = unary { ["AND"] unary }
+//	unary   = ("NOT" | "-") unary | primary
+//	primary = "(" or ")" | term | field ":" term
+//	term    = (word | "phrase") ["~" [digit]]
+//
+// Operators must be written in capitals; lower-case and, or and not are
+// searched for like any other word. A ~ makes a term fuzzy, with the edit
+// distance given after it or scaled to the term's length.
+type queryParser struct {
+	query  string
+	tokens []lexeme
+	next   int
+	depth  int
+}
+
+// parseQuery parses a search query such as
+// `cat AND ("nine lives" OR file:animals) NOT len:>200`.
+func parseQuery(query string) (queryNode, error) {
+	p := &queryParser{query: query}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	if p.peek().kind == lexEOF {
+		return nil, p.errorAt(0, "query is empty")
+	}
+
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != lexEOF {
+		return nil, p.errorAt(tok.pos, "unexpected "+tok.describe())
+	}
+	return node, nil
+}
+
+func (p *queryParser) errorAt(pos int, message string) *QuerySyntaxError {
+	return &QuerySyntaxError{
+		Query:    p.query,
+		Position: utf8.RuneCountInString(p.query[:pos]),
+		Message:  message,
+	}
+}
+
+func (p *queryParser) peek() lexeme { return p.tokens[p.next] }
+
+func (p *queryParser) advance() lexeme {
+	tok := p.tokens[p.next]
+	if tok.kind != lexEOF {
+		p.next++
+	}
+	return tok
+}
+
+// lex splits the query into lexemes, ending with lexEOF.
+func (p *queryParser) lex() error {
+	q := p.query
+	for i := 0; i < len(q); {
+		r, size := utf8.DecodeRuneInString(q[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '(':
+			p.tokens = append(p.tokens, lexeme{kind: lexLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			p.tokens = append(p.tokens, lexeme{kind: lexRParen, text: ")", pos: i})
+			i++
+		case r == '"':
+			text, end, err := p.lexPhrase(i)
+			if err != nil {
+				return err
+			}
+			tok := lexeme{kind: lexPhrase, text: text, pos: i}
+			if tok.fuzz, i, err = p.lexFuzz(end); err != nil {
+				return err
+			}
+			p.tokens = append(p.tokens, tok)
+		case r == '-' && startsNegation(q[i+1:]):
+			p.tokens = append(p.tokens, lexeme{kind: lexNot, text: "-", pos: i})
+			i++
+		default:
+			end := i
+			for end < len(q) && !isQueryDelimiter(q[end:]) {
+				_, n := utf8.DecodeRuneInString(q[end:])
+				end += n
+			}
+			tok, next, err := p.lexWord(i, end)
+			if err != nil {
+				return err
+			}
+			p.tokens = append(p.tokens, tok)
+			i = next
+		}
+	}
+	p.tokens = append(p.tokens, lexeme{kind: lexEOF, pos: len(q)})
+	return nil
+}
+
+// lexPhrase reads the quoted phrase opening at start and returns its text
+// and the offset just past the closing quote.
+func (p *queryParser) lexPhrase(start int) (string, int, error) {
+	end := strings.IndexByte(p.query[start+1:], '"')
+	if end < 0 {
+		return "", 0, p.errorAt(start, "unterminated quoted phrase")
+	}
+	return p.query[start+1 : start+1+end], start + end + 2, nil
+}
+
+// lexFuzz reads the ~ or ~N that may follow a quoted phrase at start, and
+// returns the edit distance and the offset just past it.
+func (p *queryParser) lexFuzz(start int) (int, int, error) {
+	if start >= len(p.query) || p.query[start] != '~' {
+		return fuzzUnset, start, nil
+	}
+	end := start + 1
+	for end < len(p.query) && '0' <= p.query[end] && p.query[end] <= '9' {
+		end++
+	}
+	fuzz, err := p.fuzzValue(p.query[start+1:end], start+1)
+	return fuzz, end, err
+}
+
+// splitFuzz separates a trailing ~ or ~N from word, which starts at pos.
+func (p *queryParser) splitFuzz(word string, pos int) (string, int, error) {
+	i := strings.LastIndexByte(word, '~')
+	if i < 0 || strings.Trim(word[i+1:], "0123456789") != "" {
+		return word, fuzzUnset, nil
+	}
+	fuzz, err := p.fuzzValue(word[i+1:], pos+i+1)
+	return word[:i], fuzz, err
+}
+
+// fuzzValue reads the digits after a ~, which start at pos.
+func (p *queryParser) fuzzValue(digits string, pos int) (int, error) {
+	if digits == "" {
+		return fuzzAuto, nil
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || n > maxFuzzyDistance {
+		return 0, p.errorAt(pos, fmt.Sprintf("edit distance must be between 0 and %d", maxFuzzyDistance))
+	}
+	return n, nil
+}
+
+// lexWord classifies the bare word q[start:end] as an operator, a field or
+// a plain word. A field's value may be a quoted phrase following the colon.
+func (p *queryParser) lexWord(start, end int) (lexeme, int, error) {
+	word := p.query[start:end]
+	switch word {
+	case "AND":
+		return lexeme{kind: lexAnd, text: word, pos: start}, end, nil
+	case "OR":
+		return lexeme{kind: lexOr, text: word, pos: start}, end, nil
+	case "NOT":
+		return lexeme{kind: lexNot, text: word, pos: start}, end, nil
+	}
+
+	name, value, found := strings.Cut(word, ":")
+	if !found || !isFieldName(name) {
+		text, fuzz, err := p.splitFuzz(word, start)
+		if err != nil {
+			return lexeme{}, 0, err
+		}
+		return lexeme{kind: lexWord, text: text, pos: start, fuzz: fuzz}, end, nil
+	}
+	field := strings.ToLower(name)
+	if !queryFields[field] {
+		return lexeme{}, 0, p.errorAt(start, fmt.Sprintf("unknown field %q; expected one of author, file, len", name))
+	}
+
+	tok := lexeme{kind: lexField, field: field, text: value, pos: start, valuePos: start + len(name) + 1}
+	next := end
+	var err error
+	switch {
+	case value != "":
+		tok.text, tok.fuzz, err = p.splitFuzz(value, tok.valuePos)
+	case end < len(p.query) && p.query[end] == '"':
+		if tok.text, next, err = p.lexPhrase(end); err == nil {
+			tok.fuzz, next, err = p.lexFuzz(next)
+		}
+	default:
+		err = p.errorAt(tok.valuePos, fmt.Sprintf("missing value for %s:", field))
+	}
+	if err != nil {
+		return lexeme{}, 0, err
+	}
+	if tok.fuzz != fuzzUnset && field != "author" {
+		return lexeme{}, 0, p.errorAt(tok.valuePos, fmt.Sprintf("%s: values cannot be fuzzy", field))
+	}
+	return tok, next, nil
+}
+
+// isQueryDelimiter reports whether s starts with a character that ends a
+// bare word.
+func isQueryDelimiter(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsSpace(r) || r == '(' || r == ')' || r == '"'
+}
+
+// startsNegation reports whether a '-' followed by s negates what comes
+// next, as in -cat or -(a OR b), rather than standing alone.
+func startsNegation(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return s != "" && !unicode.IsSpace(r) && r != ')'
+}
+
+func isFieldName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r > unicode.MaxASCII || !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	children := []queryNode{first}
+	for p.peek().kind == lexOr {
+		p.advance()
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, next)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return &orNode{children: children}, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	children := []queryNode{first}
+	for {
+		switch p.peek().kind {
+		case lexAnd:
+			p.advance()
+		case lexWord, lexPhrase, lexField, lexNot, lexLParen:
+			// Terms side by side are joined by an implicit AND.
+		default:
+			if len(children) == 1 {
+				return first, nil
+			}
+			return &andNode{children: children}, nil
+		}
+		next, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, next)
+	}
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	if p.peek().kind != lexNot {
+		return p.parsePrimary()
+	}
+
+	tok := p.advance()
+	if err := p.enter(tok.pos); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	child, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &notNode{child: child}, nil
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	tok := p.advance()
+	switch tok.kind {
+	case lexLParen:
+		if err := p.enter(tok.pos); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		switch closing := p.advance(); closing.kind {
+		case lexRParen:
+			return node, nil
+		case lexEOF:
+			return nil, p.errorAt(tok.pos, "unclosed parenthesis")
+		default:
+			return nil, p.errorAt(closing.pos, "expected ) but found "+closing.describe())
+		}
+	case lexWord, lexPhrase:
+		phrase, err := p.phrase(tok.text, tok.pos)
+		if err != nil {
+			return nil, err
+		}
+		return &termNode{phrase: phrase, fuzz: tok.fuzz}, nil
+	case lexField:
+		return p.field(tok)
+	}
+	return nil, p.errorAt(tok.pos, "expected a search term but found "+tok.describe())
+}
+
+// enter descends one level of nesting, refusing to go past maxQueryDepth.
+func (p *queryParser) enter(pos int) error {
+	if p.depth >= maxQueryDepth {
+		return p.errorAt(pos, fmt.Sprintf("query nests deeper than %d levels", maxQueryDepth))
+	}
+	p.depth++
+	return nil
+}
+
+func (p *queryParser) leave() { p.depth-- }
+
+// phrase splits text into the terms it must match.
+func (p *queryParser) phrase(text string, pos int) ([]string, error) {
+	phrase := terms(tokenize(text))
+	if len(phrase) == 0 {
+		return nil, p.errorAt(pos, fmt.Sprintf("%q contains no words to search for", text))
+	}
+	return phrase, nil
+}
+
+func (p *queryParser) field(tok lexeme) (queryNode, error) {
+	switch tok.field {
+	case "author":
+		phrase, err := p.phrase(tok.text, tok.valuePos)
+		if err != nil {
+			return nil, err
+		}
+		return &authorNode{termNode{phrase: phrase, fuzz: tok.fuzz}}, nil
+	case "file":
+		if _, err := path.Match(tok.text, ""); err != nil {
+			return nil, p.errorAt(tok.valuePos, fmt.Sprintf("invalid file pattern %q", tok.text))
+		}
+		return &fileNode{pattern: tok.text}, nil
+	default:
+		node, ok := parseLength(tok.text)
+		if !ok {
+			return nil, p.errorAt(tok.valuePos, fmt.Sprintf("invalid length %q; expected a number, a comparison such as <200, or a range such as 100..200", tok.text))
+		}
+		return node, nil
+	}
+}
+
+// parseLength reads the value of a len: qualifier: N, =N, <N, <=N, >N, >=N
+// or N..M.
+func parseLength(value string) (*lenNode, bool) {
+	if lo, hi, isRange := strings.Cut(value, ".."); isRange {
+		min, err1 := strconv.Atoi(lo)
+		max, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || min < 0 || max < min {
+			return nil, false
+		}
+		return &lenNode{min: min, max: max, op: value}, true
+	}
+
+	op := strings.TrimRight(value, "0123456789")
+	n, err := strconv.Atoi(value[len(op):])
+	if err != nil {
+		return nil, false
+	}
+	node := &lenNode{min: 0, max: math.MaxInt, op: value}
+	// <0 and >MaxInt would leave nothing to match, and the bound of the
+	// latter wraps around to match everything.
+	switch op {
+	case "", "=":
+		node.min, node.max = n, n
+	case "<":
+		if n == 0 {
+			return nil, false
+		}
+		node.max = n - 1
+	case "<=":
+		node.max = n
+	case ">":
+		if n == math.MaxInt {
+			return nil, false
+		}
+		node.min = n + 1
+	case ">=":
+		node.min = n
+	default:
+		return nil, false
+	}
+	return node, true
+}
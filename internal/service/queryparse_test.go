@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{query: "cat", expected: `"cat"`},
+		{query: "Cat  DOG", expected: `("cat" AND "dog")`},
+		{query: `cat "nine lives"`, expected: `("cat" AND "nine lives")`},
+		{query: "mc^2", expected: `"mc 2"`},
+		{query: "cat AND dog OR bird", expected: `(("cat" AND "dog") OR "bird")`},
+		{query: "cat AND (dog OR bird)", expected: `("cat" AND ("dog" OR "bird"))`},
+		{query: "NOT cat", expected: `NOT "cat"`},
+		{query: "cat -dog -(a OR b)", expected: `("cat" AND NOT "dog" AND NOT ("a" OR "b"))`},
+		{query: "self-help", expected: `"self help"`},
+		{query: "cat and not dog", expected: `("cat" AND "and" AND "not" AND "dog")`},
+		{query: "file:science", expected: "file:science"},
+		{query: "FILE:sci*", expected: "file:sci*"},
+		{query: "author:Twain", expected: `author:"twain"`},
+		{query: `author:"Mark Twain" len:<200`, expected: `(author:"mark twain" AND len:<200)`},
+		{query: "len:100..200 OR len:>=500", expected: "(len:100..200 OR len:>=500)"},
+		{query: `"http://example.com"`, expected: `"http example com"`},
+		{query: "einstien~ shakespear~1 cat~0", expected: `("einstien"~ AND "shakespear"~1 AND "cat"~0)`},
+		{query: `"nine lifes"~2 author:twian~`, expected: `("nine lifes"~2 AND author:"twian"~)`},
+		{query: `author:"mark twian"~1`, expected: `author:"mark twian"~1`},
+		{query: "a~b", expected: `"a b"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			q, err := parseQuery(tc.query)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, q.String())
+		})
+	}
+}
+
+func TestParseLength(t *testing.T) {
+	testCases := []struct {
+		value    string
+		min, max int
+	}{
+		{value: "120", min: 120, max: 120},
+		{value: "=120", min: 120, max: 120},
+		{value: "<200", min: 0, max: 199},
+		{value: "<=200", min: 0, max: 200},
+		{value: ">10", min: 11, max: math.MaxInt},
+		{value: ">=10", min: 10, max: math.MaxInt},
+		{value: "10..20", min: 10, max: 20},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			node, ok := parseLength(tc.value)
+			require.True(t, ok)
+			assert.Equal(t, tc.min, node.min)
+			assert.Equal(t, tc.max, node.max)
+		})
+	}
+
+	for _, value := range []string{"", "abc", "<", "=<5", "20..10", "-5..", "1.5"} {
+		_, ok := parseLength(value)
+		assert.False(t, ok, value)
+	}
+}
+
+func TestParseQuery_SyntaxErrors(t *testing.T) {
+	testCases := []struct {
+		query    string
+		position int
+		message  string
+	}{
+		{query: "", position: 0, message: "empty"},
+		{query: "   ", position: 0, message: "empty"},
+		{query: "cat AND", position: 7, message: "expected a search term but found end of query"},
+		{query: "OR cat", position: 0, message: "expected a search term but found OR"},
+		{query: "cat )", position: 4, message: "unexpected )"},
+		{query: "(cat OR dog", position: 0, message: "unclosed parenthesis"},
+		{query: "()", position: 1, message: "expected a search term but found )"},
+		{query: `cat "nine lives`, position: 4, message: "unterminated"},
+		{query: "cat !!", position: 4, message: "no words"},
+		{query: `""`, position: 0, message: "no words"},
+		{query: "colour:red", position: 0, message: `unknown field "colour"`},
+		{query: "cat file:", position: 9, message: "missing value for file:"},
+		{query: "len:lots", position: 4, message: "invalid length"},
+		{query: "len:>9223372036854775807", position: 4, message: "invalid length"},
+		{query: "len:<0", position: 4, message: "invalid length"},
+		{query: "file:[a", position: 5, message: "invalid file pattern"},
+		{query: `author:"mark`, position: 7, message: "unterminated"},
+		{query: "café )", position: 5, message: "unexpected )"},
+		{query: "einstien~3", position: 9, message: "edit distance must be between 0 and 2"},
+		{query: `cat "nine lifes"~9`, position: 17, message: "edit distance"},
+		{query: "file:science~1", position: 5, message: "cannot be fuzzy"},
+		{query: strings.Repeat("(", maxQueryDepth+1) + "cat", position: maxQueryDepth, message: "nests deeper"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			_, err := parseQuery(tc.query)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, ErrBadPattern)
+
+			var qerr *QuerySyntaxError
+			require.True(t, errors.As(err, &qerr))
+			assert.Equal(t, tc.query, qerr.Query)
+			assert.Equal(t, tc.position, qerr.Position)
+			assert.Contains(t, qerr.Message, tc.message)
+		})
+	}
+}
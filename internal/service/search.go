@@ -0,0 +1,323 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Orders a search can return its matches in.
+const (
+	SearchByRelevance = "relevance"
+	SearchByFile      = "file"
+	SearchByLength    = "length"
+)
+
+// BM25 parameters: how quickly repeated words stop adding to the score, and
+// how much long fortunes are penalised.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// SearchPage selects which matches of a search are returned and in what
+// order. An empty Sort ranks queries by relevance and patterns by file
+// order; a zero Limit returns every match from Offset on. Cursor, taken
+// from a previous response, replaces Offset. Facets names the facets to
+// count over every match.
+type SearchPage struct {
+	Sort   string
+	Offset int
+	Limit  int
+	Cursor string
+	Facets []string
+}
+
+// Validate checks the sort order and the page bounds.
+func (p SearchPage) Validate() error {
+	verr := &ValidationError{}
+	switch p.Sort {
+	case "", SearchByRelevance, SearchByFile, SearchByLength:
+	default:
+		verr.Add("sort", p.Sort, CodeInvalidValue, "must be one of relevance, file, length")
+	}
+	if p.Offset < 0 {
+		verr.Add("offset", strconv.Itoa(p.Offset), CodeOutOfRange, "must not be negative")
+	}
+	if p.Limit < 0 {
+		verr.Add("limit", strconv.Itoa(p.Limit), CodeOutOfRange, "must not be negative")
+	}
+	if p.Cursor != "" && p.Offset != 0 {
+		verr.Add("cursor", p.Cursor, CodeConflict, "cannot be combined with offset")
+	}
+	for _, facet := range p.Facets {
+		if !validFacet(facet) {
+			verr.Add("facets", facet, CodeInvalidValue, "must be one of file, length")
+		}
+	}
+	return verr.ErrOrNil()
+}
+
+// forQuery fills in the default order of a query search.
+func (p SearchPage) forQuery() SearchPage {
+	if p.Sort == "" {
+		p.Sort = SearchByRelevance
+	}
+	return p
+}
+
+// forPattern fills in the default order of a pattern search, which has no
+// relevance scores to rank by.
+func (p SearchPage) forPattern() (SearchPage, error) {
+	switch p.Sort {
+	case "":
+		p.Sort = SearchByFile
+	case SearchByRelevance:
+		return p, &ValidationError{Errors: []FieldError{{
+			Field: "sort", Value: p.Sort, Code: CodeConflict,
+			Message: "relevance only applies to q, not pattern",
+		}}}
+	}
+	return p, nil
+}
+
+// ranked is a search match with what ordering and facets need to know
+// about it.
+type ranked struct {
+	match SearchMatch
+	// distance is the edits its fuzzy terms needed; see queryMatch.
+	distance int
+	// length is the length of the fortune as stored, which the length
+	// filters measure.
+	length int
+}
+
+// pageResults orders the matches of a search as page asks and cuts out the
+// requested page. search names the search, so that a cursor can only
+// continue the search it came from.
+func pageResults(results []ranked, search string, opts FortuneOptions, page SearchPage) (*SearchResponse, error) {
+	key, offset, err := pageStart(search, opts, page)
+	if err != nil {
+		return nil, err
+	}
+
+	sortResults(results, page.Sort)
+	total := len(results)
+	start := min(offset, total)
+	end := total
+	if page.Limit > 0 {
+		end = min(start+page.Limit, total)
+	}
+
+	matches := make([]SearchMatch, 0, end-start)
+	for _, r := range results[start:end] {
+		matches = append(matches, r.match)
+	}
+	resp := &SearchResponse{
+		Matches: matches,
+		Count:   len(matches),
+		Total:   total,
+		Facets:  countFacets(results, page.Facets, opts),
+		Seed:    opts.Seed,
+	}
+	if end < total {
+		resp.NextCursor = encodeCursor(end, key)
+	}
+	return resp, nil
+}
+
+// pageStart checks page and returns the key of the search and the offset
+// the page starts at, taken from the cursor if there is one.
+func pageStart(search string, opts FortuneOptions, page SearchPage) (key string, offset int, err error) {
+	if err := page.Validate(); err != nil {
+		return "", 0, err
+	}
+
+	key = searchKey(search, opts, page.Sort)
+	if page.Cursor == "" {
+		return key, page.Offset, nil
+	}
+	offset, ok := decodeCursor(page.Cursor, key)
+	if !ok {
+		return "", 0, &ValidationError{Errors: []FieldError{{
+			Field: "cursor", Value: page.Cursor, Code: CodeInvalidValue,
+			Message: "does not continue this search",
+		}}}
+	}
+	return key, offset, nil
+}
+
+// sortResults puts results, found in file order, into the given order.
+func sortResults(results []ranked, order string) {
+	switch order {
+	case SearchByRelevance:
+		// Closer fuzzy matches first, then by score; ties keep file order.
+		sort.SliceStable(results, func(i, j int) bool {
+			if results[i].distance != results[j].distance {
+				return results[i].distance < results[j].distance
+			}
+			return results[i].match.Score > results[j].match.Score
+		})
+	case SearchByLength:
+		sort.SliceStable(results, func(i, j int) bool {
+			return len(results[i].match.Fortune) < len(results[j].match.Fortune)
+		})
+	}
+}
+
+// MatchFunc receives the matches of a streamed search one at a time.
+// Returning an error stops the search, which then returns that error.
+type MatchFunc func(SearchMatch) error
+
+// SearchStreamer is implemented by services that can hand over the matches
+// of a search as they find them, instead of building the whole page first.
+// Matches in file order are passed on as soon as they are found; any other
+// order has to see every match before the first one is passed on. A zero
+// Limit streams every match. Facets are not counted.
+type SearchStreamer interface {
+	StreamSearch(ctx context.Context, pattern string, opts FortuneOptions, page SearchPage, fn MatchFunc) error
+	StreamQuery(ctx context.Context, query string, opts FortuneOptions, page SearchPage, fn MatchFunc) error
+}
+
+// errPageFull stops a search once a streamed page has every match it needs.
+var errPageFull = errors.New("page full")
+
+// streamer feeds the matches of a search to a MatchFunc in the order its
+// page asks for, skipping up to the offset and stopping at the limit.
+type streamer struct {
+	page SearchPage
+	fn   MatchFunc
+	// skip is how many more matches to drop before the page starts.
+	skip int
+	sent int
+	// held keeps back matches until finish when they have to be sorted.
+	held []ranked
+}
+
+func newStreamer(search string, opts FortuneOptions, page SearchPage, fn MatchFunc) (*streamer, error) {
+	_, offset, err := pageStart(search, opts, page)
+	if err != nil {
+		return nil, err
+	}
+	return &streamer{page: page, fn: fn, skip: offset}, nil
+}
+
+// add takes the next match in file order. It returns errPageFull once the
+// page is complete.
+func (st *streamer) add(r ranked) error {
+	if st.page.Sort != SearchByFile {
+		st.held = append(st.held, r)
+		return nil
+	}
+	return st.send(r.match)
+}
+
+func (st *streamer) send(m SearchMatch) error {
+	if st.skip > 0 {
+		st.skip--
+		return nil
+	}
+	st.sent++
+	if err := st.fn(m); err != nil {
+		return err
+	}
+	if st.page.Limit > 0 && st.sent >= st.page.Limit {
+		return errPageFull
+	}
+	return nil
+}
+
+// finish sends whatever add held back, given the error the search ended
+// with, and returns the error the stream as a whole ended with.
+func (st *streamer) finish(err error) error {
+	if errors.Is(err, errPageFull) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	sortResults(st.held, st.page.Sort)
+	for _, r := range st.held {
+		if err := st.send(r.match); err != nil {
+			if errors.Is(err, errPageFull) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// patternSearch and querySearch name searches for pageResults.
+func patternSearch(pattern string) string { return "pattern\x00" + pattern }
+
+func querySearch(query string) string { return "q\x00" + query }
+
+// searchKey identifies a search and its order.
+func searchKey(search string, opts FortuneOptions, order string) string {
+	opts.Seed = ""
+	encoded, _ := json.Marshal(opts)
+	sum := sha256.Sum256([]byte(search + "\x00" + string(encoded) + "\x00" + order))
+	return hex.EncodeToString(sum[:8])
+}
+
+// encodeCursor returns an opaque cursor for the matches from offset on.
+func encodeCursor(offset int, key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset) + ":" + key))
+}
+
+// decodeCursor returns the offset a cursor points at, and false if it is
+// malformed or belongs to another search.
+func decodeCursor(cursor, key string) (int, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, false
+	}
+	offsetStr, cursorKey, found := strings.Cut(string(raw), ":")
+	if !found || cursorKey != key {
+		return 0, false
+	}
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		return 0, false
+	}
+	return offset, true
+}
+
+// bm25 scores a match by Okapi BM25 over the words it matched. Approximate
+// matches count for less the further they are from the query.
+func (snap *snapshot) bm25(hits []hit, docLen int) float64 {
+	type matched struct {
+		word     string
+		distance int
+	}
+	tf := make(map[matched]int)
+	for _, h := range hits {
+		for _, w := range h.words {
+			tf[matched{w.Matched, w.Distance}]++
+		}
+	}
+
+	n := float64(len(snap.docs))
+	lengthNorm := 1 - bm25B
+	if snap.avgLen > 0 {
+		lengthNorm += bm25B * float64(docLen) / snap.avgLen
+	}
+
+	var score float64
+	for m, count := range tf {
+		df := float64(len(snap.postings[m.word]))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		f := float64(count)
+		score += idf * f * (bm25K1 + 1) / (f + bm25K1*lengthNorm) / float64(1+m.distance)
+	}
+	return math.Round(score*1e4) / 1e4
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchPageValidate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		page   SearchPage
+		fields []string
+	}{
+		{name: "Empty", page: SearchPage{}},
+		{name: "Every sort", page: SearchPage{Sort: SearchByLength, Offset: 5, Limit: 10}},
+		{name: "Unknown sort", page: SearchPage{Sort: "random"}, fields: []string{"sort"}},
+		{name: "Negative bounds", page: SearchPage{Offset: -1, Limit: -1}, fields: []string{"offset", "limit"}},
+		{name: "Cursor and offset", page: SearchPage{Offset: 5, Cursor: "abc"}, fields: []string{"cursor"}},
+		{name: "Facets", page: SearchPage{Facets: []string{FacetFile, FacetLength}}},
+		{name: "Unknown facet", page: SearchPage{Facets: []string{FacetFile, "author"}}, fields: []string{"facets"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.page.Validate()
+			if tc.fields == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var verr *ValidationError
+			require.ErrorAs(t, err, &verr)
+			var fields []string
+			for _, fe := range verr.Errors {
+				fields = append(fields, fe.Field)
+			}
+			assert.Equal(t, tc.fields, fields)
+		})
+	}
+}
+
+func TestSearchPageForPattern(t *testing.T) {
+	page, err := SearchPage{}.forPattern()
+	require.NoError(t, err)
+	assert.Equal(t, SearchByFile, page.Sort)
+
+	_, err = SearchPage{Sort: SearchByRelevance}.forPattern()
+	assert.True(t, isValidationError(err))
+
+	assert.Equal(t, SearchByRelevance, SearchPage{}.forQuery().Sort)
+	assert.Equal(t, SearchByLength, SearchPage{Sort: SearchByLength}.forQuery().Sort)
+}
+
+func testResults() []ranked {
+	return []ranked{
+		{match: SearchMatch{Index: 0, FortuneResponse: FortuneResponse{Fortune: "a medium fortune"}, Score: 1.5}},
+		{match: SearchMatch{Index: 1, FortuneResponse: FortuneResponse{Fortune: "short"}, Score: 0.5}, distance: 1},
+		{match: SearchMatch{Index: 2, FortuneResponse: FortuneResponse{Fortune: "the longest fortune of all"}, Score: 2.5}},
+		{match: SearchMatch{Index: 3, FortuneResponse: FortuneResponse{Fortune: "tie"}, Score: 1.5}},
+	}
+}
+
+func matchIndexes(resp *SearchResponse) []int {
+	indexes := make([]int, len(resp.Matches))
+	for i, m := range resp.Matches {
+		indexes[i] = m.Index
+	}
+	return indexes
+}
+
+func TestPageResults(t *testing.T) {
+	testCases := []struct {
+		name     string
+		page     SearchPage
+		expected []int
+	}{
+		{name: "File order", page: SearchPage{Sort: SearchByFile}, expected: []int{0, 1, 2, 3}},
+		{name: "Relevance", page: SearchPage{Sort: SearchByRelevance}, expected: []int{2, 0, 3, 1}},
+		{name: "Length", page: SearchPage{Sort: SearchByLength}, expected: []int{3, 1, 0, 2}},
+		{name: "Limit", page: SearchPage{Sort: SearchByFile, Limit: 2}, expected: []int{0, 1}},
+		{name: "Offset", page: SearchPage{Sort: SearchByFile, Offset: 3, Limit: 2}, expected: []int{3}},
+		{name: "Past the end", page: SearchPage{Sort: SearchByFile, Offset: 10}, expected: []int{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := pageResults(testResults(), "q\x00cat", FortuneOptions{Seed: "s"}, tc.page)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, matchIndexes(resp))
+			assert.Equal(t, len(tc.expected), resp.Count)
+			assert.Equal(t, 4, resp.Total)
+			assert.Equal(t, "s", resp.Seed)
+			assert.Nil(t, resp.Facets)
+		})
+	}
+}
+
+func TestPageResults_Cursor(t *testing.T) {
+	page := SearchPage{Sort: SearchByRelevance, Limit: 3}
+	first, err := pageResults(testResults(), "q\x00cat", FortuneOptions{}, page)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 0, 3}, matchIndexes(first))
+	require.NotEmpty(t, first.NextCursor)
+
+	page.Cursor = first.NextCursor
+	second, err := pageResults(testResults(), "q\x00cat", FortuneOptions{}, page)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, matchIndexes(second))
+	assert.Empty(t, second.NextCursor, "the last page has no cursor")
+
+	for name, reuse := range map[string]func() (*SearchResponse, error){
+		"Other query": func() (*SearchResponse, error) {
+			return pageResults(testResults(), "q\x00dog", FortuneOptions{}, page)
+		},
+		"Other options": func() (*SearchResponse, error) {
+			return pageResults(testResults(), "q\x00cat", FortuneOptions{Short: true}, page)
+		},
+		"Other order": func() (*SearchResponse, error) {
+			return pageResults(testResults(), "q\x00cat", FortuneOptions{}, SearchPage{Sort: SearchByFile, Cursor: page.Cursor})
+		},
+		"Garbage": func() (*SearchResponse, error) {
+			return pageResults(testResults(), "q\x00cat", FortuneOptions{}, SearchPage{Sort: SearchByRelevance, Cursor: "%%%"})
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := reuse()
+			var verr *ValidationError
+			require.ErrorAs(t, err, &verr)
+			assert.Equal(t, "cursor", verr.Errors[0].Field)
+		})
+	}
+}
+
+func TestStreamer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		page     SearchPage
+		expected []int
+		// added is how many matches the search got to hand over.
+		added int
+	}{
+		{name: "File order", page: SearchPage{Sort: SearchByFile}, expected: []int{0, 1, 2, 3}, added: 4},
+		{name: "Stops at the limit", page: SearchPage{Sort: SearchByFile, Offset: 1, Limit: 2}, expected: []int{1, 2}, added: 3},
+		{name: "Relevance", page: SearchPage{Sort: SearchByRelevance, Limit: 3}, expected: []int{2, 0, 3}, added: 4},
+		{name: "Length", page: SearchPage{Sort: SearchByLength, Offset: 2}, expected: []int{0, 2}, added: 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var sent []int
+			st, err := newStreamer("q\x00cat", FortuneOptions{}, tc.page, func(m SearchMatch) error {
+				sent = append(sent, m.Index)
+				return nil
+			})
+			require.NoError(t, err)
+
+			added := 0
+			var searchErr error
+			for _, r := range testResults() {
+				added++
+				if searchErr = st.add(r); searchErr != nil {
+					break
+				}
+			}
+			require.NoError(t, st.finish(searchErr))
+			assert.Equal(t, tc.expected, sent)
+			assert.Equal(t, tc.added, added)
+		})
+	}
+
+	t.Run("Continues a cursor", func(t *testing.T) {
+		page := SearchPage{Sort: SearchByRelevance, Limit: 3}
+		first, err := pageResults(testResults(), "q\x00cat", FortuneOptions{}, page)
+		require.NoError(t, err)
+
+		var sent []int
+		st, err := newStreamer("q\x00cat", FortuneOptions{}, SearchPage{Sort: SearchByRelevance, Cursor: first.NextCursor}, func(m SearchMatch) error {
+			sent = append(sent, m.Index)
+			return nil
+		})
+		require.NoError(t, err)
+		for _, r := range testResults() {
+			require.NoError(t, st.add(r))
+		}
+		require.NoError(t, st.finish(nil))
+		assert.Equal(t, []int{1}, sent)
+	})
+
+	t.Run("Errors stop the stream", func(t *testing.T) {
+		gone := errors.New("client gone")
+		st, err := newStreamer("q\x00cat", FortuneOptions{}, SearchPage{Sort: SearchByFile}, func(SearchMatch) error {
+			return gone
+		})
+		require.NoError(t, err)
+		assert.ErrorIs(t, st.finish(st.add(testResults()[0])), gone)
+	})
+
+	t.Run("Invalid page", func(t *testing.T) {
+		_, err := newStreamer("q\x00cat", FortuneOptions{}, SearchPage{Sort: SearchByFile, Cursor: "%%%"}, nil)
+		assert.True(t, isValidationError(err))
+	})
+}
+
+func TestBM25(t *testing.T) {
+	snap := &snapshot{
+		docs:     make([]document, 10),
+		postings: map[string][]int32{"rare": {0}, "common": {0, 1, 2, 3, 4, 5, 6, 7}},
+		avgLen:   10,
+	}
+	word := func(w string, d int) hit {
+		return hit{words: []FuzzyMatch{{Term: w, Matched: w, Distance: d}}}
+	}
+
+	rare := snap.bm25([]hit{word("rare", 0)}, 10)
+	common := snap.bm25([]hit{word("common", 0)}, 10)
+	assert.Greater(t, rare, common, "rarer words weigh more")
+
+	assert.Greater(t, snap.bm25([]hit{word("rare", 0), word("rare", 0)}, 10), rare, "repeats add to the score")
+	assert.Greater(t, snap.bm25([]hit{word("rare", 0)}, 5), rare, "shorter fortunes score higher")
+	assert.Less(t, snap.bm25([]hit{word("rare", 1)}, 10), rare, "approximate matches score lower")
+}
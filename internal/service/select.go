@@ -0,0 +1,310 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"fortune-api/internal/fortunefile"
+
+	"go.uber.org/zap"
+)
+
+// defaultShortLength is fortune's default boundary between short and long
+// fortunes, used when FortuneOptions.Length is not set.
+const defaultShortLength = 160
+
+// source is a database taking part in a selection, with its chance of being
+// picked as a percentage. size counts the fortunes that may be picked from
+// it. When some are excluded, texts holds the whole file, read to count
+// them.
+type source struct {
+	db     database
+	file   *fortunefile.File
+	size   int
+	texts  []string
+	weight float64
+}
+
+// all returns every fortune of the source in index order, excluded or not.
+func (src source) all() ([]string, error) {
+	if src.texts != nil {
+		return src.texts, nil
+	}
+	return src.file.All()
+}
+
+// weighSources assigns each source a percentage the way fortune does.
+// Files given an explicit percentage keep it; the remainder is shared by the
+// other files in proportion to their number of fortunes, or evenly when
+// opts.Equal is set; excluded fortunes don't count. Percentages pair up with
+// opts.Files by position.
+func weighSources(sources []source, opts FortuneOptions) {
+	fixed := make([]bool, len(sources))
+	remaining := 100.0
+
+	if len(opts.Files) > 0 {
+		for i := range sources {
+			if i >= len(opts.Percentages) || opts.Percentages[i] == "" {
+				continue
+			}
+			if p, err := strconv.ParseFloat(opts.Percentages[i], 64); err == nil {
+				sources[i].weight = p
+				fixed[i] = true
+				remaining -= p
+			}
+		}
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	var total float64
+	for i, src := range sources {
+		if !fixed[i] {
+			total += shareOf(src, opts)
+		}
+	}
+
+	for i, src := range sources {
+		if fixed[i] {
+			continue
+		}
+		if total == 0 {
+			sources[i].weight = 0
+			continue
+		}
+		sources[i].weight = remaining * shareOf(src, opts) / total
+	}
+}
+
+func shareOf(src source, opts FortuneOptions) float64 {
+	if opts.Equal {
+		return 1
+	}
+	return float64(src.size)
+}
+
+// newSeed returns a random seed for a selection that wasn't given one.
+func newSeed() string {
+	return strconv.FormatUint(rand.Uint64(), 16)
+}
+
+// newRand returns the generator for one selection. The same seed always
+// yields the same sequence.
+func newRand(seed string) *rand.Rand {
+	sum := sha256.Sum256([]byte(seed))
+	return rand.New(rand.NewPCG(binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:16])))
+}
+
+// pickSource returns the index of a source chosen by weight, or -1 if no
+// source has a positive weight.
+func pickSource(rng *rand.Rand, sources []source) int {
+	var total float64
+	for _, src := range sources {
+		total += src.weight
+	}
+	if total <= 0 {
+		return -1
+	}
+
+	target := rng.Float64() * total
+	for i, src := range sources {
+		if src.weight <= 0 {
+			continue
+		}
+		target -= src.weight
+		if target < 0 {
+			return i
+		}
+	}
+
+	// Floating point rounding can leave a sliver; fall back to the last
+	// source that could have been chosen.
+	for i := len(sources) - 1; i >= 0; i-- {
+		if sources[i].weight > 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// lengthAllowed applies the short/long filters of fortune's -s, -l and -n,
+// and the size limits.
+func lengthAllowed(text string, opts FortuneOptions) bool {
+	limit := shortLength(opts)
+	if opts.Short && len(text) > limit {
+		return false
+	}
+	if opts.Long && len(text) <= limit {
+		return false
+	}
+	return sizeAllowed(text, opts)
+}
+
+// hasSizeLimits reports whether any of MinLength, MaxLength, MaxLines and
+// MaxWidth is set.
+func (opts FortuneOptions) hasSizeLimits() bool {
+	return opts.MinLength > 0 || opts.MaxLength > 0 || opts.MaxLines > 0 || opts.MaxWidth > 0
+}
+
+// sizeAllowed applies MinLength, MaxLength, MaxLines and MaxWidth to text
+// as it would be returned.
+func sizeAllowed(text string, opts FortuneOptions) bool {
+	if !opts.hasSizeLimits() {
+		return true
+	}
+	text = strings.TrimSpace(text)
+
+	length := utf8.RuneCountInString(text)
+	if length < opts.MinLength || (opts.MaxLength > 0 && length > opts.MaxLength) {
+		return false
+	}
+	if opts.MaxLines > 0 && strings.Count(text, "\n")+1 > opts.MaxLines {
+		return false
+	}
+	if opts.MaxWidth > 0 && textWidth(text) > opts.MaxWidth {
+		return false
+	}
+	return true
+}
+
+// tabWidth is the distance between tab stops, as on a terminal.
+const tabWidth = 8
+
+// textWidth returns the length in characters of the longest line of text,
+// with tabs expanded to the next tab stop.
+func textWidth(text string) int {
+	widest := 0
+	for _, line := range strings.Split(text, "\n") {
+		column := 0
+		for _, r := range line {
+			if r == '\t' {
+				column += tabWidth - column%tabWidth
+			} else {
+				column++
+			}
+		}
+		widest = max(widest, column)
+	}
+	return widest
+}
+
+// shortLength returns the longest a short fortune may be.
+func shortLength(opts FortuneOptions) int {
+	if opts.Length > 0 {
+		return opts.Length
+	}
+	return defaultShortLength
+}
+
+// compilePattern compiles a search pattern, honouring IgnoreCase.
+func compilePattern(pattern string, ignoreCase bool) (*regexp.Regexp, error) {
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// filterPattern compiles opts.Match, which restricts a draw to the fortunes
+// it matches. It returns nil if there is none.
+func filterPattern(opts FortuneOptions) (*regexp.Regexp, error) {
+	if opts.Match == "" {
+		return nil, nil
+	}
+	re, err := compilePattern(opts.Match, opts.IgnoreCase)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadPattern, err)
+	}
+	return re, nil
+}
+
+// highlights returns the byte ranges of text matched by re.
+func highlights(re *regexp.Regexp, text string) []Span {
+	locs := re.FindAllStringIndex(text, -1)
+	spans := make([]Span, len(locs))
+	for i, loc := range locs {
+		spans[i] = Span{Start: loc[0], End: loc[1]}
+	}
+	return spans
+}
+
+// FileProbability is a file's chance of supplying the next fortune, as
+// reported by `fortune -f`.
+type FileProbability struct {
+	Name       string  `json:"name"`
+	Offensive  bool    `json:"offensive"`
+	Fortunes   int     `json:"fortunes"`
+	Percentage float64 `json:"percentage"`
+}
+
+// openSources resolves the databases named in opts.Files, or every database
+// when none are named, and loads their indexes. When fortunes are excluded,
+// by opts.ExcludeIDs or the denylist, each file is read to size it without
+// them.
+func openSources(ctx context.Context, catalog *Catalog, opts FortuneOptions, logger *zap.Logger) ([]source, error) {
+	dbs, err := selectDatabases(catalog, opts)
+	if err != nil {
+		if errors.Is(err, ErrBackendUnavailable) {
+			logger.Error("Failed to read fortune directories", zap.Error(err), zap.Strings("directories", catalog.Paths()))
+		}
+		return nil, err
+	}
+
+	sources := make([]source, 0, len(dbs))
+	for _, db := range dbs {
+		if err := ctx.Err(); err != nil {
+			return nil, contextError(err)
+		}
+		file, err := fortunefile.Open(db.Path)
+		if err != nil {
+			logger.Error("Failed to open fortune file", zap.Error(err), zap.String("file", db.Path))
+			return nil, fmt.Errorf("could not open fortune file: %w", err)
+		}
+		src := source{db: db, file: file, size: file.Len()}
+		if len(opts.ExcludeIDs) > 0 || catalog.hasDenylist() {
+			if src.texts, err = file.All(); err != nil {
+				logger.Error("Failed to read fortune file", zap.Error(err), zap.String("file", db.Path))
+				return nil, fmt.Errorf("could not read fortune file: %w", err)
+			}
+			src.size = 0
+			for _, text := range src.texts {
+				if !catalog.excluded(db, text, opts) {
+					src.size++
+				}
+			}
+		}
+		sources = append(sources, src)
+	}
+
+	return sources, nil
+}
+
+// probabilities weighs the files opts selects. Like `fortune -f` it ignores
+// the length filters and patterns, which only apply once a file is chosen,
+// but excluded fortunes are neither counted nor weighed.
+func probabilities(ctx context.Context, catalog *Catalog, opts FortuneOptions, logger *zap.Logger) ([]FileProbability, error) {
+	sources, err := openSources(ctx, catalog, opts, logger)
+	if err != nil {
+		return nil, err
+	}
+	weighSources(sources, opts)
+
+	probs := make([]FileProbability, len(sources))
+	for i, src := range sources {
+		probs[i] = FileProbability{
+			Name:       src.db.Name,
+			Offensive:  src.db.Offensive,
+			Fortunes:   src.size,
+			Percentage: src.weight,
+		}
+	}
+	return probs, nil
+}
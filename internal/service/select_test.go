@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"math/rand/v2"
+	"testing"
+
+	"fortune-api/internal/fortunefile"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// sizedSource returns a source whose file reports n fortunes.
+func sizedSource(name string, n int) source {
+	return source{
+		db: database{Name: name},
+		file: &fortunefile.File{Index: &fortunefile.Index{
+			Header: fortunefile.Header{NumStr: uint32(n)},
+		}},
+		size: n,
+	}
+}
+
+func weights(sources []source) []float64 {
+	w := make([]float64, len(sources))
+	for i, src := range sources {
+		w[i] = src.weight
+	}
+	return w
+}
+
+func TestWeighSources(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opts     FortuneOptions
+		expected []float64
+	}{
+		{
+			name:     "By size",
+			opts:     FortuneOptions{},
+			expected: []float64{25, 75},
+		},
+		{
+			name:     "Equal",
+			opts:     FortuneOptions{Equal: true},
+			expected: []float64{50, 50},
+		},
+		{
+			name:     "Percentage with remainder",
+			opts:     FortuneOptions{Files: []string{"a", "b"}, Percentages: []string{"10"}},
+			expected: []float64{10, 90},
+		},
+		{
+			name:     "Percentages ignored without files",
+			opts:     FortuneOptions{Percentages: []string{"10"}},
+			expected: []float64{25, 75},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sources := []source{sizedSource("a", 10), sizedSource("b", 30)}
+			weighSources(sources, tc.opts)
+			assert.InDeltaSlice(t, tc.expected, weights(sources), 1e-9)
+		})
+	}
+}
+
+func TestPickSource(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	sources := []source{{weight: 0}, {weight: 100}, {weight: 0}}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, 1, pickSource(rng, sources))
+	}
+
+	assert.Equal(t, -1, pickSource(rng, []source{{weight: 0}}))
+	assert.Equal(t, -1, pickSource(rng, nil))
+}
+
+func TestLengthAllowed(t *testing.T) {
+	assert.True(t, lengthAllowed("short", FortuneOptions{Short: true}))
+	assert.False(t, lengthAllowed("short", FortuneOptions{Long: true}))
+	assert.True(t, lengthAllowed("short", FortuneOptions{Long: true, Length: 3}))
+	assert.False(t, lengthAllowed("short", FortuneOptions{Short: true, Length: 3}))
+	assert.True(t, lengthAllowed("anything", FortuneOptions{}))
+}
+
+func TestSizeAllowed(t *testing.T) {
+	const poem = "Roses are red,\n\tviolets are blue.\n\t\t-- Anonymous\n"
+
+	testCases := []struct {
+		name     string
+		opts     FortuneOptions
+		expected bool
+	}{
+		{name: "No limits", opts: FortuneOptions{}, expected: true},
+		{name: "Within every limit", opts: FortuneOptions{MinLength: 48, MaxLength: 48, MaxLines: 3, MaxWidth: 28}, expected: true},
+		{name: "Too short", opts: FortuneOptions{MinLength: 49}, expected: false},
+		{name: "Too long", opts: FortuneOptions{MaxLength: 47}, expected: false},
+		{name: "Too many lines", opts: FortuneOptions{MaxLines: 2}, expected: false},
+		{name: "Too wide", opts: FortuneOptions{MaxWidth: 27}, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, sizeAllowed(poem, tc.opts))
+			assert.Equal(t, tc.expected, lengthAllowed(poem, tc.opts))
+		})
+	}
+
+	assert.True(t, sizeAllowed("héllo", FortuneOptions{MaxLength: 5}), "length counts characters, not bytes")
+	assert.False(t, lengthAllowed("short", FortuneOptions{Long: true, MaxLength: 10}), "the short/long filters still apply")
+}
+
+func TestTextWidth(t *testing.T) {
+	testCases := []struct {
+		text     string
+		expected int
+	}{
+		{text: "", expected: 0},
+		{text: "abc", expected: 3},
+		{text: "abc\nabcdef\nab", expected: 6},
+		{text: "\tx", expected: 9},
+		{text: "abc\tx", expected: 9},
+		{text: "abcdefgh\tx", expected: 17},
+		{text: "naïve", expected: 5},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, textWidth(tc.text), "%q", tc.text)
+	}
+}
+
+func TestProbabilities(t *testing.T) {
+	catalog := newTestCatalog(newTestCorpus(t), false)
+	ctx := context.Background()
+
+	testCases := []struct {
+		name     string
+		opts     FortuneOptions
+		expected map[string]float64
+	}{
+		{
+			name:     "By size",
+			opts:     FortuneOptions{},
+			expected: map[string]float64{"animals": 60, "science": 40},
+		},
+		{
+			name:     "Equal with offensive",
+			opts:     FortuneOptions{Equal: true, Offensive: OffensiveInclude},
+			expected: map[string]float64{"animals": 100.0 / 3, "rude": 100.0 / 3, "science": 100.0 / 3},
+		},
+		{
+			name:     "Fixed percentage",
+			opts:     FortuneOptions{Files: []string{"science", "animals"}, Percentages: []string{"90"}},
+			expected: map[string]float64{"science": 90, "animals": 10},
+		},
+		{
+			name:     "Excluded file",
+			opts:     FortuneOptions{Offensive: OffensiveInclude, ExcludeFiles: []string{"animals"}},
+			expected: map[string]float64{"rude": 100.0 / 3, "science": 200.0 / 3},
+		},
+		{
+			name: "Excluded fortunes",
+			opts: FortuneOptions{ExcludeIDs: []string{
+				fortuneID(database{Name: "animals"}, "The cat sat on the mat."),
+				fortuneID(database{Name: "animals"}, "A dog is a man's best friend."),
+			}},
+			expected: map[string]float64{"animals": 100.0 / 3, "science": 200.0 / 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			probs, err := probabilities(ctx, catalog, tc.opts, zap.NewNop())
+			require.NoError(t, err)
+
+			got := make(map[string]float64)
+			for _, p := range probs {
+				got[p.Name] = p.Percentage
+			}
+			require.Len(t, got, len(tc.expected))
+			for name, want := range tc.expected {
+				assert.InDelta(t, want, got[name], 1e-9, name)
+			}
+		})
+	}
+
+	d, err := NewDenylist(nil, []string{"^E"})
+	require.NoError(t, err)
+	denied := NewCatalog([]Directory{{Path: catalog.Paths()[0], OffensiveDir: testOffensiveDir}}, false, d)
+	probs, err := probabilities(ctx, denied, FortuneOptions{}, zap.NewNop())
+	require.NoError(t, err)
+	require.Len(t, probs, 2)
+	assert.Equal(t, 3, probs[0].Fortunes)
+	assert.Equal(t, 0, probs[1].Fortunes, "both science fortunes are retired")
+	assert.InDelta(t, 100, probs[0].Percentage, 1e-9)
+
+	_, err = probabilities(ctx, catalog, FortuneOptions{Files: []string{"missing"}}, zap.NewNop())
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+}
+
+func TestHighlights(t *testing.T) {
+	re, err := compilePattern("an", true)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Span{{Start: 0, End: 2}, {Start: 5, End: 7}, {Start: 11, End: 13}, {Start: 13, End: 15}}, highlights(re, "An orange banana"))
+	assert.Empty(t, highlights(re, "nothing here"))
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// SimilarFortune is a fortune found to be like another one. Similarity is
+// the cosine of the angle between their TF-IDF term vectors, from 0 for
+// nothing in common to 1 for the same words in the same proportions.
+type SimilarFortune struct {
+	FortuneResponse
+	Index      int     `json:"index"`
+	Similarity float64 `json:"similarity"`
+}
+
+// SimilarResponse lists the fortunes most like the one with ID, closest
+// first.
+type SimilarResponse struct {
+	ID      string           `json:"id"`
+	Similar []SimilarFortune `json:"similar"`
+	Count   int              `json:"count"`
+}
+
+// maxSimilarCandidates bounds how many fortunes a similarity search scores.
+const maxSimilarCandidates = 2000
+
+// termVector is a fortune's words weighted by TF-IDF, with its length.
+type termVector struct {
+	weights map[string]float64
+	norm    float64
+}
+
+// similarFortunes ranks the fortunes of the databases opts selects by how
+// similar they are to the fortune with the given ID, and returns the first
+// count of them; a zero count returns all that share a word with it. Word
+// weights come from the whole index, so the same fortune scores the same
+// whatever the selection.
+func similarFortunes(ctx context.Context, index *Index, catalog *Catalog, id string, opts FortuneOptions, count int, logger *zap.Logger) (*SimilarResponse, error) {
+	name, ok := parseFortuneID(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrFortuneNotFound, id)
+	}
+
+	all, err := catalog.Databases(OffensiveInclude)
+	if err != nil {
+		logger.Error("Failed to read fortune directories", zap.Error(err), zap.Strings("directories", catalog.Paths()))
+		return nil, fmt.Errorf("%w: could not read fortune directory: %w", ErrBackendUnavailable, err)
+	}
+	dbs, err := selectDatabases(catalog, opts)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]bool, len(dbs))
+	for _, db := range dbs {
+		allowed[db.Path] = true
+	}
+
+	snap, err := index.snapshotFor(ctx, all)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Error("Failed to index fortune files", zap.Error(err))
+		}
+		return nil, err
+	}
+
+	target := -1
+	for i, doc := range snap.docs {
+		if doc.db.Name == name && fortuneID(doc.db, doc.text) == id {
+			target = i
+			break
+		}
+	}
+	if target < 0 || catalog.retired(id, snap.docs[target].text) {
+		return nil, fmt.Errorf("%w: %q", ErrFortuneNotFound, id)
+	}
+
+	vec := snap.termVector(snap.docs[target].text)
+	var similar []SimilarFortune
+	for _, docID := range snap.relatedDocs(vec) {
+		if err := ctx.Err(); err != nil {
+			return nil, contextError(err)
+		}
+
+		doc := snap.docs[docID]
+		if !allowed[doc.db.Path] || !lengthAllowed(doc.text, opts) || catalog.excluded(doc.db, doc.text, opts) {
+			continue
+		}
+		docFortuneID := fortuneID(doc.db, doc.text)
+		if docFortuneID == id {
+			continue
+		}
+		similarity := vec.cosine(snap.termVector(doc.text))
+		if similarity <= 0 {
+			continue
+		}
+		similar = append(similar, SimilarFortune{
+			FortuneResponse: FortuneResponse{
+				ID:         docFortuneID,
+				Fortune:    strings.TrimSpace(doc.text),
+				SourceFile: doc.db.Name,
+			},
+			Index:      doc.index,
+			Similarity: similarity,
+		})
+	}
+
+	// Ties keep index order, which relatedDocs returns.
+	sort.SliceStable(similar, func(i, j int) bool {
+		return similar[i].Similarity > similar[j].Similarity
+	})
+	if count > 0 && len(similar) > count {
+		similar = similar[:count]
+	}
+	if similar == nil {
+		similar = []SimilarFortune{}
+	}
+	return &SimilarResponse{ID: id, Similar: similar, Count: len(similar)}, nil
+}
+
+// idf is the inverse document frequency of term: the rarer it is, the more
+// it says about a fortune. A word found in every fortune weighs nothing.
+func (snap *snapshot) idf(term string) float64 {
+	df := len(snap.postings[term])
+	if df == 0 {
+		return 0
+	}
+	return math.Log(float64(len(snap.docs)) / float64(df))
+}
+
+// termVector weighs the words of text by how often they occur in it and
+// how rare they are in the index.
+func (snap *snapshot) termVector(text string) termVector {
+	tf := make(map[string]int)
+	for _, t := range tokenize(text) {
+		tf[t.term]++
+	}
+
+	vec := termVector{weights: make(map[string]float64, len(tf))}
+	var sum float64
+	for term, n := range tf {
+		w := float64(n) * snap.idf(term)
+		if w == 0 {
+			continue
+		}
+		vec.weights[term] = w
+		sum += w * w
+	}
+	vec.norm = math.Sqrt(sum)
+	return vec
+}
+
+// relatedDocs returns the documents that share a word with vec, in index
+// order. Words are taken rarest first, and once maxSimilarCandidates
+// documents are found the commoner ones are left out: they weigh little,
+// and would bring in most of the index.
+func (snap *snapshot) relatedDocs(vec termVector) []int32 {
+	terms := make([]string, 0, len(vec.weights))
+	for term := range vec.weights {
+		terms = append(terms, term)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		ni, nj := len(snap.postings[terms[i]]), len(snap.postings[terms[j]])
+		if ni != nj {
+			return ni < nj
+		}
+		return terms[i] < terms[j]
+	})
+
+	var ids []int32
+	for _, term := range terms {
+		if len(ids) >= maxSimilarCandidates {
+			break
+		}
+		ids = union(ids, snap.postings[term])
+	}
+	return ids
+}
+
+// cosine returns the cosine similarity of two term vectors, rounded to four
+// decimal places.
+func (v termVector) cosine(other termVector) float64 {
+	if v.norm == 0 || other.norm == 0 {
+		return 0
+	}
+	small, large := v.weights, other.weights
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+	var dot float64
+	for term, w := range small {
+		dot += w * large[term]
+	}
+	return math.Round(dot/(v.norm*other.norm)*1e4) / 1e4
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newSimilarCorpus(t *testing.T) *Catalog {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "proverbs", []string{
+		"The early bird catches the worm.",
+		"The early worm gets eaten by the bird.",
+		"A bird in the hand is worth two in the bush.",
+		"Time flies like an arrow; fruit flies like bananas.",
+		"Early to bed and early to rise.",
+	}, 0)
+	writeTestDatabase(t, dir, "birds", []string{
+		"The bird sings at dawn.",
+	}, 0)
+	return newTestCatalog(dir, false)
+}
+
+func similarIndexes(resp *SimilarResponse) []int {
+	indexes := make([]int, len(resp.Similar))
+	for i, s := range resp.Similar {
+		indexes[i] = s.Index
+	}
+	return indexes
+}
+
+func TestSimilarFortunes(t *testing.T) {
+	catalog := newSimilarCorpus(t)
+	ctx := context.Background()
+	target := fortuneID(database{Name: "proverbs"}, "The early bird catches the worm.")
+
+	for name, index := range map[string]*Index{"Built": newTestIndex(t, catalog), "Without index": nil} {
+		t.Run(name, func(t *testing.T) {
+			s := NewNativeService(catalog, index, zap.NewNop())
+
+			resp, err := s.SimilarFortunes(ctx, target, FortuneOptions{}, 0)
+			require.NoError(t, err)
+			assert.Equal(t, target, resp.ID)
+			require.Equal(t, 4, resp.Count, "the fortune itself and those with nothing in common are left out")
+			assert.Equal(t, "The early worm gets eaten by the bird.", resp.Similar[0].Fortune)
+			assert.Equal(t, 1, resp.Similar[0].Index)
+			assert.Equal(t, "proverbs", resp.Similar[0].SourceFile)
+			assert.Equal(t, fortuneID(database{Name: "proverbs"}, resp.Similar[0].Fortune), resp.Similar[0].ID)
+			for i, similar := range resp.Similar {
+				assert.NotEqual(t, 3, similar.Index)
+				assert.Greater(t, similar.Similarity, 0.0)
+				assert.Less(t, similar.Similarity, 1.0)
+				if i > 0 {
+					assert.LessOrEqual(t, similar.Similarity, resp.Similar[i-1].Similarity, "closest first")
+				}
+			}
+
+			resp, err = s.SimilarFortunes(ctx, target, FortuneOptions{}, 1)
+			require.NoError(t, err)
+			assert.Equal(t, []int{1}, similarIndexes(resp))
+
+			resp, err = s.SimilarFortunes(ctx, target, FortuneOptions{Files: []string{"birds"}}, 0)
+			require.NoError(t, err)
+			require.Equal(t, 1, resp.Count, "only the selected files are searched")
+			assert.Equal(t, "birds", resp.Similar[0].SourceFile)
+		})
+	}
+}
+
+func TestSimilarFortunes_NotFound(t *testing.T) {
+	s := NewNativeService(newSimilarCorpus(t), nil, zap.NewNop())
+	ctx := context.Background()
+
+	_, err := s.SimilarFortunes(ctx, "proverbs-0000000000000000", FortuneOptions{}, 5)
+	assert.ErrorIs(t, err, ErrFortuneNotFound)
+
+	_, err = s.SimilarFortunes(ctx, "not an id", FortuneOptions{}, 5)
+	assert.ErrorIs(t, err, ErrFortuneNotFound)
+}
+
+func TestSimilarFortunes_NothingInCommon(t *testing.T) {
+	s := NewNativeService(newSimilarCorpus(t), nil, zap.NewNop())
+	target := fortuneID(database{Name: "proverbs"}, "Time flies like an arrow; fruit flies like bananas.")
+
+	resp, err := s.SimilarFortunes(context.Background(), target, FortuneOptions{}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 0, resp.Count)
+	assert.NotNil(t, resp.Similar)
+}
+
+func TestTermVectorCosine(t *testing.T) {
+	snap := &snapshot{
+		docs:     make([]document, 4),
+		postings: map[string][]int32{"cat": {0}, "dog": {1}, "the": {0, 1, 2, 3}},
+	}
+
+	cat := snap.termVector("The cat.")
+	assert.Equal(t, map[string]float64{"cat": snap.idf("cat")}, cat.weights, "words in every fortune weigh nothing")
+	assert.Equal(t, 1.0, cat.cosine(snap.termVector("CAT, the cat!")))
+	assert.Equal(t, 0.0, cat.cosine(snap.termVector("The dog.")))
+	assert.Equal(t, 0.0, cat.cosine(snap.termVector("The.")))
+
+	both := snap.termVector("The cat and the dog.")
+	assert.InDelta(t, 0.7071, cat.cosine(both), 1e-4)
+}
@@ -0,0 +1,289 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxSeedLength bounds FortuneOptions.Seed.
+const maxSeedLength = 64
+
+// Machine-readable codes carried by FieldError.
+const (
+	CodeInvalidBoolean = "invalid_boolean"
+	CodeInvalidInteger = "invalid_integer"
+	CodeInvalidValue   = "invalid_value"
+	CodeOutOfRange     = "out_of_range"
+	CodeConflict       = "conflicting_options"
+	CodeTooManyValues  = "too_many_values"
+	CodeInvalidName    = "invalid_file_name"
+	CodeUnknownFile    = "unknown_file"
+)
+
+// FieldError describes one invalid request option.
+type FieldError struct {
+	Field   string `json:"field"`
+	Value   string `json:"value"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when request options are rejected before any
+// fortune lookup takes place. It lists every problem found, not just the
+// first.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fmt.Sprintf("%s: %q %s", fe.Field, fe.Value, fe.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Add records a problem with a single field.
+func (e *ValidationError) Add(field, value, code, message string) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Value: value, Code: code, Message: message})
+}
+
+// Merge appends the field errors held by err, if it is a ValidationError.
+// It reports whether err was merged.
+func (e *ValidationError) Merge(err error) bool {
+	var other *ValidationError
+	if !errors.As(err, &other) {
+		return false
+	}
+	e.Errors = append(e.Errors, other.Errors...)
+	return true
+}
+
+// isValidationError reports whether err carries a ValidationError.
+func isValidationError(err error) bool {
+	var verr *ValidationError
+	return errors.As(err, &verr)
+}
+
+// ErrOrNil returns e only if it holds at least one field error, so callers
+// don't end up with a non-nil error interface wrapping an empty list.
+func (e *ValidationError) ErrOrNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks the options for values fortune would reject or silently
+// misread: contradictory flags, a non-positive length, negative or crossed
+// size limits, an overlong seed, an out-of-range fuzzy distance, malformed
+// excluded IDs, files both named and excluded, and percentages that are
+// malformed, outnumber the files or add up to more than 100.
+func (opts FortuneOptions) Validate() error {
+	verr := &ValidationError{}
+
+	if opts.Long && opts.Short {
+		verr.Add("long", "true", CodeConflict, "cannot be combined with short")
+	}
+	switch opts.Offensive {
+	case "", OffensiveExclude, OffensiveInclude, OffensiveOnly:
+	default:
+		verr.Add("offensive", string(opts.Offensive), CodeInvalidValue, "must be one of exclude, include, only")
+	}
+	if opts.All && opts.Offensive != "" && opts.Offensive != OffensiveInclude {
+		verr.Add("all", "true", CodeConflict, "cannot be combined with offensive="+string(opts.Offensive))
+	}
+	if opts.Match != "" && opts.Pattern != "" {
+		verr.Add("match", opts.Match, CodeConflict, "cannot be combined with pattern")
+	}
+	if opts.Length < 0 {
+		verr.Add("length", strconv.Itoa(opts.Length), CodeOutOfRange, "must be a positive number")
+	}
+	for _, limit := range []struct {
+		field string
+		value int
+	}{
+		{"min_length", opts.MinLength},
+		{"max_length", opts.MaxLength},
+		{"max_lines", opts.MaxLines},
+		{"max_width", opts.MaxWidth},
+	} {
+		if limit.value < 0 {
+			verr.Add(limit.field, strconv.Itoa(limit.value), CodeOutOfRange, "must be a positive number")
+		}
+	}
+	if opts.MaxLength > 0 && opts.MinLength > opts.MaxLength {
+		verr.Add("min_length", strconv.Itoa(opts.MinLength), CodeConflict, "cannot be greater than max_length")
+	}
+	if opts.FuzzyDistance < 0 || opts.FuzzyDistance > maxFuzzyDistance {
+		verr.Add("distance", strconv.Itoa(opts.FuzzyDistance), CodeOutOfRange, fmt.Sprintf("must be between 1 and %d", maxFuzzyDistance))
+	} else if opts.FuzzyDistance > 0 && !opts.Fuzzy {
+		verr.Add("distance", strconv.Itoa(opts.FuzzyDistance), CodeConflict, "requires fuzzy")
+	}
+	if len(opts.Seed) > maxSeedLength {
+		verr.Add("seed", opts.Seed, CodeOutOfRange, fmt.Sprintf("must be at most %d characters", maxSeedLength))
+	}
+
+	for _, id := range opts.ExcludeIDs {
+		if _, ok := parseFortuneID(id); !ok {
+			verr.Add("exclude_ids", id, CodeInvalidValue, "is not a fortune ID")
+		}
+	}
+	for _, name := range opts.ExcludeFiles {
+		if slices.Contains(opts.Files, name) {
+			verr.Add("exclude_files", name, CodeConflict, "is also named in files")
+		}
+	}
+
+	if len(opts.Percentages) > len(opts.Files) {
+		verr.Add("percentages", strings.Join(opts.Percentages, ","), CodeTooManyValues,
+			fmt.Sprintf("has %d entries but only %d files were given", len(opts.Percentages), len(opts.Files)))
+	}
+
+	sum, given, valid := 0, 0, len(opts.Percentages) <= len(opts.Files)
+	for _, p := range opts.Percentages {
+		if p == "" {
+			continue
+		}
+		given++
+		n, err := strconv.Atoi(p)
+		if err != nil || strings.ContainsAny(p, "+-") {
+			verr.Add("percentages", p, CodeInvalidInteger, "is not a whole number between 0 and 100")
+			valid = false
+			continue
+		}
+		if n < 0 || n > 100 {
+			verr.Add("percentages", p, CodeOutOfRange, "is not a whole number between 0 and 100")
+			valid = false
+			continue
+		}
+		sum += n
+	}
+	// Like fortune, whatever the percentages leave goes to the files without
+	// one, so there must be both some left and a file to take it.
+	switch {
+	case !valid || given == 0:
+	case sum > 100:
+		verr.Add("percentages", strings.Join(opts.Percentages, ","), CodeOutOfRange,
+			fmt.Sprintf("add up to %d, more than 100", sum))
+	case sum < 100 && given == len(opts.Files):
+		verr.Add("percentages", strings.Join(opts.Percentages, ","), CodeOutOfRange,
+			fmt.Sprintf("add up to %d, but every file has one, so nothing takes the rest", sum))
+	case sum == 100 && given < len(opts.Files):
+		verr.Add("percentages", strings.Join(opts.Percentages, ","), CodeOutOfRange,
+			"add up to 100, leaving nothing for the files without one")
+	}
+
+	return verr.ErrOrNil()
+}
+
+// resolveSelection validates opts and returns the databases opts.Files refer
+// to. Only bare names of databases known to the catalog are accepted, so
+// nothing from the request can reach the fortune binary as a flag or an
+// arbitrary path.
+func resolveSelection(catalog *Catalog, opts FortuneOptions) ([]database, error) {
+	verr := &ValidationError{}
+	verr.Merge(opts.Validate())
+
+	mode, err := catalog.offensiveMode(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbs []database
+	for _, name := range opts.Files {
+		if !validFileName(name) {
+			verr.Add("files", name, CodeInvalidName, "is not a valid fortune file name")
+			continue
+		}
+		if catalog == nil {
+			verr.Add("files", name, CodeUnknownFile, "is not a known fortune file")
+			continue
+		}
+		db, err := catalog.Lookup(name, mode)
+		if errors.Is(err, ErrFileNotFound) {
+			verr.Add("files", name, CodeUnknownFile, "is not a known fortune file")
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: could not read fortune directory: %w", ErrBackendUnavailable, err)
+		}
+		dbs = append(dbs, db)
+	}
+
+	for _, name := range opts.ExcludeFiles {
+		if !validFileName(name) {
+			verr.Add("exclude_files", name, CodeInvalidName, "is not a valid fortune file name")
+			continue
+		}
+		if catalog == nil {
+			verr.Add("exclude_files", name, CodeUnknownFile, "is not a known fortune file")
+			continue
+		}
+		_, err := catalog.Lookup(name, OffensiveInclude)
+		if errors.Is(err, ErrFileNotFound) {
+			verr.Add("exclude_files", name, CodeUnknownFile, "is not a known fortune file")
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: could not read fortune directory: %w", ErrBackendUnavailable, err)
+		}
+	}
+
+	if err := verr.ErrOrNil(); err != nil {
+		return nil, err
+	}
+	return dbs, nil
+}
+
+// selectDatabases returns the databases a request draws from: the ones named
+// in opts.Files, or every database allowed by the offensive setting but not
+// in opts.ExcludeFiles when no files are named. Selecting nothing is
+// ErrNoMatch, unless there are no fortune files at all.
+func selectDatabases(catalog *Catalog, opts FortuneOptions) ([]database, error) {
+	dbs, err := resolveSelection(catalog, opts)
+	if err != nil || len(opts.Files) > 0 {
+		return dbs, err
+	}
+
+	mode, err := catalog.offensiveMode(opts)
+	if err != nil {
+		return nil, err
+	}
+	dbs, err = catalog.Databases(mode)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not read fortune directory: %w", ErrBackendUnavailable, err)
+	}
+	if len(dbs) == 0 {
+		// The directories were read; there is just nothing offensive in them.
+		if mode == OffensiveOnly {
+			return nil, fmt.Errorf("%w: no offensive fortune files found", ErrNoMatch)
+		}
+		return nil, fmt.Errorf("%w: no fortune files found", ErrBackendUnavailable)
+	}
+
+	if len(opts.ExcludeFiles) > 0 {
+		dbs = slices.DeleteFunc(dbs, func(db database) bool {
+			return slices.Contains(opts.ExcludeFiles, db.Name)
+		})
+		if len(dbs) == 0 {
+			return nil, fmt.Errorf("%w: every fortune file is excluded", ErrNoMatch)
+		}
+	}
+	return dbs, nil
+}
+
+// validFileName accepts plain database names: no path separators, no
+// relative components and nothing that could be parsed as a flag.
+func validFileName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	if strings.HasPrefix(name, "-") || strings.HasPrefix(name, ".") {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\\x00%")
+}
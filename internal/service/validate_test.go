@@ -0,0 +1,120 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSelection(t *testing.T) {
+	dir := newTestCorpus(t)
+	catalog := newTestCatalog(dir, false)
+
+	// A database outside the catalog that traversal attempts aim for.
+	outside := filepath.Dir(dir)
+	writeTestDatabase(t, outside, "escape", []string{"outside"}, 0)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes"), []byte("no index"), 0o644))
+
+	testCases := []struct {
+		name    string
+		opts    FortuneOptions
+		invalid []string
+	}{
+		{name: "Known files", opts: FortuneOptions{Files: []string{"animals", "science"}}},
+		{name: "Offensive with all", opts: FortuneOptions{Files: []string{"rude"}, All: true}},
+		{name: "Offensive without all", opts: FortuneOptions{Files: []string{"rude"}}, invalid: []string{"rude"}},
+		{name: "Flag injection", opts: FortuneOptions{Files: []string{"-o"}}, invalid: []string{"-o"}},
+		{name: "Absolute path", opts: FortuneOptions{Files: []string{"/etc/passwd"}}, invalid: []string{"/etc/passwd"}},
+		{name: "Traversal", opts: FortuneOptions{Files: []string{"../escape"}}, invalid: []string{"../escape"}},
+		{name: "Dot names", opts: FortuneOptions{Files: []string{"..", ".hidden"}}, invalid: []string{"..", ".hidden"}},
+		{name: "Unindexed file", opts: FortuneOptions{Files: []string{"notes"}}, invalid: []string{"notes"}},
+		{name: "Percentage sign", opts: FortuneOptions{Files: []string{"50%"}}, invalid: []string{"50%"}},
+		{name: "Excluded offensive file", opts: FortuneOptions{ExcludeFiles: []string{"rude"}}},
+		{name: "Excluded unknown file", opts: FortuneOptions{ExcludeFiles: []string{"zebras", "../escape"}}, invalid: []string{"zebras", "../escape"}},
+		{
+			name:    "Bad percentages",
+			opts:    FortuneOptions{Files: []string{"animals", "animals", "animals", "animals", "animals"}, Percentages: []string{"abc", "-5", "101", "+3", ""}},
+			invalid: []string{"abc", "-5", "101", "+3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dbs, err := resolveSelection(catalog, tc.opts)
+			if len(tc.invalid) == 0 {
+				require.NoError(t, err)
+				assert.Len(t, dbs, len(tc.opts.Files))
+				return
+			}
+
+			var verr *ValidationError
+			require.ErrorAs(t, err, &verr)
+			var values []string
+			for _, fe := range verr.Errors {
+				values = append(values, fe.Value)
+			}
+			assert.ElementsMatch(t, tc.invalid, values)
+			for _, v := range tc.invalid {
+				assert.Contains(t, verr.Error(), v, "message should name the offending entry")
+			}
+		})
+	}
+}
+
+func TestFortuneOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		opts  FortuneOptions
+		codes []string
+	}{
+		{name: "Empty", opts: FortuneOptions{}},
+		{name: "Valid percentages", opts: FortuneOptions{Files: []string{"a", "b", "c"}, Percentages: []string{"60", "", "30"}}},
+		{name: "Percentages for every file", opts: FortuneOptions{Files: []string{"a", "b"}, Percentages: []string{"60", "40"}}},
+		{name: "Long and short", opts: FortuneOptions{Long: true, Short: true}, codes: []string{CodeConflict}},
+		{name: "Negative length", opts: FortuneOptions{Length: -1}, codes: []string{CodeOutOfRange}},
+		{name: "Size limits", opts: FortuneOptions{MinLength: 10, MaxLength: 10, MaxLines: 2, MaxWidth: 40}},
+		{name: "Negative size limits", opts: FortuneOptions{MinLength: -1, MaxLength: -1, MaxLines: -1, MaxWidth: -1}, codes: []string{CodeOutOfRange, CodeOutOfRange, CodeOutOfRange, CodeOutOfRange}},
+		{name: "Exclusions", opts: FortuneOptions{ExcludeFiles: []string{"zippy"}, ExcludeIDs: []string{"zippy-5b0e17a2c4d9f836"}}},
+		{name: "Malformed excluded ID", opts: FortuneOptions{ExcludeIDs: []string{"zippy"}}, codes: []string{CodeInvalidValue}},
+		{name: "File named and excluded", opts: FortuneOptions{Files: []string{"zippy"}, ExcludeFiles: []string{"zippy"}}, codes: []string{CodeConflict}},
+		{name: "Minimum over maximum", opts: FortuneOptions{MinLength: 11, MaxLength: 10}, codes: []string{CodeConflict}},
+		{name: "Match with pattern", opts: FortuneOptions{Match: "cat", Pattern: "dog"}, codes: []string{CodeConflict}},
+		{name: "Seed too long", opts: FortuneOptions{Seed: strings.Repeat("x", 65)}, codes: []string{CodeOutOfRange}},
+		{name: "Fuzzy distance", opts: FortuneOptions{Fuzzy: true, FuzzyDistance: 2}},
+		{name: "Fuzzy distance too large", opts: FortuneOptions{Fuzzy: true, FuzzyDistance: 3}, codes: []string{CodeOutOfRange}},
+		{name: "Fuzzy distance without fuzzy", opts: FortuneOptions{FuzzyDistance: 1}, codes: []string{CodeConflict}},
+		{name: "Percentages over 100", opts: FortuneOptions{Files: []string{"a", "b"}, Percentages: []string{"60", "50"}}, codes: []string{CodeOutOfRange}},
+		{name: "Percentages under 100 for every file", opts: FortuneOptions{Files: []string{"a"}, Percentages: []string{"50"}}, codes: []string{CodeOutOfRange}},
+		{name: "Percentages of 100 with files left", opts: FortuneOptions{Files: []string{"a", "b"}, Percentages: []string{"100"}}, codes: []string{CodeOutOfRange}},
+		{name: "Percentages of 100 with an empty entry", opts: FortuneOptions{Files: []string{"a", "b"}, Percentages: []string{"100", ""}}, codes: []string{CodeOutOfRange}},
+		{name: "Percentages without files", opts: FortuneOptions{Percentages: []string{"10"}}, codes: []string{CodeTooManyValues}},
+		{name: "Percentage not a number", opts: FortuneOptions{Files: []string{"a"}, Percentages: []string{"ten"}}, codes: []string{CodeInvalidInteger}},
+		{
+			name:  "Every problem reported",
+			opts:  FortuneOptions{Long: true, Short: true, Files: []string{"a"}, Percentages: []string{"200", "x"}},
+			codes: []string{CodeConflict, CodeTooManyValues, CodeOutOfRange, CodeInvalidInteger},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.opts.Validate()
+			if len(tc.codes) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			var verr *ValidationError
+			require.ErrorAs(t, err, &verr)
+			var codes []string
+			for _, fe := range verr.Errors {
+				codes = append(codes, fe.Code)
+			}
+			assert.ElementsMatch(t, tc.codes, codes)
+		})
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	// Embedded so /fortune/daily?tz= works without tzdata in the image.
+	_ "time/tzdata"
 
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
@@ -28,22 +30,62 @@ func main() {
 	cfg := config.Load()
 
 	// Initialize fortune service
-	fortuneService := service.NewFortuneService(cfg.FortunePath, logger)
+	dirs := make([]service.Directory, len(cfg.FortuneDirs))
+	for i, d := range cfg.FortuneDirs {
+		dirs[i] = service.Directory{Path: d.Path, OffensiveDir: d.OffensiveDir}
+	}
+	denylist, err := service.NewDenylist(cfg.DenyIDs, cfg.DenyPatterns)
+	if err != nil {
+		logger.Fatal("Invalid fortune denylist", zap.Error(err))
+	}
+	catalog := service.NewCatalog(dirs, cfg.SafeMode, denylist)
+
+	// Build the search index up front; until it exists, queries read the
+	// files directly.
+	indexCtx, stopIndex := context.WithCancel(context.Background())
+	defer stopIndex()
+	index := service.NewIndex(catalog, logger)
+	if err := index.Build(indexCtx); err != nil {
+		logger.Error("Failed to build search index", zap.Error(err))
+	}
+	if cfg.IndexRefresh > 0 {
+		go index.Watch(indexCtx, cfg.IndexRefresh)
+	}
+
+	var fortuneService service.FortuneServiceInterface
+	switch cfg.Backend {
+	case config.BackendExec:
+		fortuneService = service.NewFortuneService(cfg.FortunePath, catalog, index, logger)
+	case config.BackendNative:
+		fortuneService = service.NewNativeService(catalog, index, logger)
+	default:
+		logger.Fatal("Unknown fortune backend", zap.String("backend", cfg.Backend))
+	}
 
 	// Initialize handlers
-	handler := handlers.NewHandler(fortuneService, logger)
+	handler := handlers.NewHandler(fortuneService, logger, handlers.Limits{
+		MaxCount:       cfg.MaxCount,
+		MaxSearchLimit: cfg.MaxSearchLimit,
+	})
 
 	// Setup routes
 	router := mux.NewRouter()
 	router.HandleFunc("/health", handler.HealthCheck).Methods("GET")
 	router.HandleFunc("/fortune", handler.GetFortune).Methods("GET")
+	router.HandleFunc("/fortune/daily", handler.GetDailyFortune).Methods("GET")
 	router.HandleFunc("/fortune/files", handler.ListFiles).Methods("GET")
+	router.HandleFunc("/fortune/files/{name}", handler.GetFile).Methods("GET")
+	router.HandleFunc("/fortune/probabilities", handler.GetProbabilities).Methods("GET")
 	router.HandleFunc("/fortune/search", handler.SearchFortunes).Methods("GET")
+	// Registered last so the fixed /fortune/... routes above take precedence.
+	router.HandleFunc("/fortune/{id}", handler.GetFortuneByID).Methods("GET")
+	router.HandleFunc("/fortune/{id}/similar", handler.GetSimilarFortunes).Methods("GET")
 
 	// Add middleware
 	router.Use(handlers.LoggingMiddleware(logger))
 	router.Use(handlers.CORSMiddleware)
 	router.Use(handlers.PerClientRateLimit)
+	router.Use(handlers.TimeoutMiddleware(cfg.RequestTimeout))
 
 	// Setup server
 	srv := &http.Server{
@@ -56,7 +98,10 @@ func main() {
 
 	// Start server in a goroutine
 	go func() {
-		logger.Info("Starting Fortune API server", zap.String("address", cfg.ServerAddress))
+		logger.Info("Starting Fortune API server",
+			zap.String("address", cfg.ServerAddress),
+			zap.String("backend", cfg.Backend),
+			zap.Int("denylist", denylist.Len()))
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logger.Fatal("Server failed to start", zap.Error(err))
 		}